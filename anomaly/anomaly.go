@@ -0,0 +1,260 @@
+// Package anomaly 按（服务，操作）维护最近一段时间的耗时分布，span 结束时耗时超过
+// p99 + K*MAD（MAD 是耗时与中位数之差的中位数）就视为异常：打上标签、强制采样这个 span
+// 以及之后结束的上游 span，并输出带 jaeger 链接的日志。用来自动发现某个操作突然从几十毫秒变成几秒的情况。默认关闭。
+package anomaly
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"opentracing-sample/config"
+	"opentracing-sample/spanstore"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 异常 span 的标签，耗时单位毫秒
+const (
+	TagAnomaly   = "anomaly"
+	TagThreshold = "anomaly.threshold_ms"
+)
+
+// maxRecent 是 ServeHTTP 中保留的最近异常数
+const maxRecent = 100
+
+// Stats 按 "服务 操作" 统计发现的异常数，通过 /debug/vars 暴露
+var Stats = expvar.NewMap("anomalies")
+
+// Config 是异常检测的参数。Window 是每个操作保留的基线样本数，样本数达到 MinSamples 之前不判断；
+// 阈值每 Refresh 个样本重新计算一次。耗时低于 MinDuration 的 span 不算异常，避免 MAD 为 0 时
+// 把 1ms 到 2ms 的波动也报出来。JaegerUI 用来拼接日志中的 trace 链接
+type Config struct {
+	Enabled     bool
+	Window      int
+	MinSamples  int
+	K           float64
+	Refresh     int
+	MinDuration time.Duration
+	JaegerUI    string
+}
+
+// DefaultConfig 是 FromEnv 启用时使用的参数
+var DefaultConfig = Config{
+	Enabled:     true,
+	Window:      1000,
+	MinSamples:  100,
+	K:           3,
+	Refresh:     50,
+	MinDuration: 10 * time.Millisecond,
+	JaegerUI:    "http://localhost:16686",
+}
+
+// Anomaly 是一个异常的 span
+type Anomaly struct {
+	Service   string        `json:"service"`
+	Operation string        `json:"operation"`
+	Duration  time.Duration `json:"duration"`
+	Threshold time.Duration `json:"threshold"`
+	TraceID   string        `json:"trace_id"`
+	Link      string        `json:"link,omitempty"`
+	Time      time.Time     `json:"time"`
+}
+
+// Baseline 是一个操作当前的基线，Threshold 为 0 表示样本还不够
+type Baseline struct {
+	Service   string        `json:"service"`
+	Operation string        `json:"operation"`
+	Samples   int           `json:"samples"`
+	P50       time.Duration `json:"p50"`
+	P99       time.Duration `json:"p99"`
+	MAD       time.Duration `json:"mad"`
+	Threshold time.Duration `json:"threshold"`
+}
+
+type key struct {
+	service, operation string
+}
+
+// distribution 是一个操作最近 Window 个耗时组成的环形缓冲区，以及据此算出的阈值
+type distribution struct {
+	samples []time.Duration
+	next    int
+	pending int
+	p50     time.Duration
+	p99     time.Duration
+	mad     time.Duration
+}
+
+func (d *distribution) add(v time.Duration, window int) {
+	d.pending++
+	if len(d.samples) < window {
+		d.samples = append(d.samples, v)
+		return
+	}
+	d.samples[d.next] = v
+	d.next = (d.next + 1) % window
+}
+
+// refresh 重新计算中位数、p99 和 MAD
+func (d *distribution) refresh() {
+	d.pending = 0
+	sorted := spanstore.SortDurations(append([]time.Duration{}, d.samples...))
+	d.p50 = spanstore.Percentile(sorted, 0.5)
+	d.p99 = spanstore.Percentile(sorted, 0.99)
+	deviations := make([]time.Duration, len(sorted))
+	for i, v := range sorted {
+		if v < d.p50 {
+			deviations[i] = d.p50 - v
+		} else {
+			deviations[i] = v - d.p50
+		}
+	}
+	d.mad = spanstore.Percentile(spanstore.SortDurations(deviations), 0.5)
+}
+
+// Detector 保存各个操作的耗时分布，可以并发使用
+type Detector struct {
+	cfg Config
+
+	mu     sync.Mutex
+	ops    map[key]*distribution
+	recent []Anomaly
+}
+
+// New 按 cfg 创建 Detector，Window、MinSamples、K 和 Refresh 未设置时使用 DefaultConfig 中的值
+func New(cfg Config) *Detector {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultConfig.Window
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = DefaultConfig.MinSamples
+	}
+	if cfg.MinSamples > cfg.Window {
+		cfg.MinSamples = cfg.Window
+	}
+	if cfg.K <= 0 {
+		cfg.K = DefaultConfig.K
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = DefaultConfig.Refresh
+	}
+	return &Detector{cfg: cfg, ops: map[key]*distribution{}}
+}
+
+// Disabled 返回不做任何检测的 Detector
+func Disabled() *Detector {
+	return New(Config{})
+}
+
+// FromEnv 只有 ANOMALY_DETECTION 为 true 时才启用，ANOMALY_K 可以调整 K，
+// JAEGER_UI 是日志中 trace 链接的地址前缀
+func FromEnv() (*Detector, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("ANOMALY_DETECTION"))
+	if !enabled {
+		return Disabled(), nil
+	}
+	cfg := DefaultConfig
+	if v := os.Getenv("ANOMALY_K"); v != "" {
+		k, err := strconv.ParseFloat(v, 64)
+		if err != nil || k <= 0 {
+			return nil, fmt.Errorf("anomaly: invalid ANOMALY_K %q", v)
+		}
+		cfg.K = k
+	}
+	if v := os.Getenv("JAEGER_UI"); v != "" {
+		cfg.JaegerUI = v
+	}
+	return New(cfg), nil
+}
+
+func (d *Detector) threshold(dist *distribution) time.Duration {
+	return dist.p99 + time.Duration(d.cfg.K*float64(dist.mad))
+}
+
+// Observe 先用当前的基线判断 duration 是否异常，再把它计入基线。
+// 异常的耗时也计入基线，持续变慢一段时间后基线随之调整，不再重复报告
+func (d *Detector) Observe(service, operation string, duration time.Duration) (threshold time.Duration, anomalous bool) {
+	if !d.cfg.Enabled {
+		return 0, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	k := key{service: service, operation: operation}
+	dist, ok := d.ops[k]
+	if !ok {
+		dist = &distribution{}
+		d.ops[k] = dist
+	}
+	if len(dist.samples) >= d.cfg.MinSamples {
+		threshold = d.threshold(dist)
+		anomalous = duration > threshold && duration >= d.cfg.MinDuration
+	}
+	dist.add(duration, d.cfg.Window)
+	if dist.pending >= d.cfg.Refresh || len(dist.samples) == d.cfg.MinSamples {
+		dist.refresh()
+	}
+	return threshold, anomalous
+}
+
+// Link 返回 trace 在 jaeger 中的地址，没有配置 JaegerUI 时返回空串
+func (d *Detector) Link(traceID string) string {
+	if d.cfg.JaegerUI == "" || traceID == "" {
+		return ""
+	}
+	return strings.TrimSuffix(d.cfg.JaegerUI, "/") + "/trace/" + traceID
+}
+
+// record 记录一个异常并输出日志
+func (d *Detector) record(a Anomaly) {
+	Stats.Add(a.Service+" "+a.Operation, 1)
+	config.Log.WithField("component", "anomaly").
+		Warnf("%s %s took %s, over threshold %s: %s", a.Service, a.Operation, a.Duration, a.Threshold, a.Link)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recent = append(d.recent, a)
+	if len(d.recent) > maxRecent {
+		d.recent = d.recent[len(d.recent)-maxRecent:]
+	}
+}
+
+// Baselines 返回各个操作当前的基线，按服务和操作排序
+func (d *Detector) Baselines() []Baseline {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	baselines := make([]Baseline, 0, len(d.ops))
+	for k, dist := range d.ops {
+		b := Baseline{Service: k.service, Operation: k.operation, Samples: len(dist.samples)}
+		if len(dist.samples) >= d.cfg.MinSamples {
+			b.P50, b.P99, b.MAD, b.Threshold = dist.p50, dist.p99, dist.mad, d.threshold(dist)
+		}
+		baselines = append(baselines, b)
+	}
+	sort.Slice(baselines, func(i, j int) bool {
+		if baselines[i].Service != baselines[j].Service {
+			return baselines[i].Service < baselines[j].Service
+		}
+		return baselines[i].Operation < baselines[j].Operation
+	})
+	return baselines
+}
+
+// Recent 返回最近发现的异常，最新的在最后
+func (d *Detector) Recent() []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Anomaly{}, d.recent...)
+}
+
+// ServeHTTP 以 JSON 输出各个操作的基线和最近的异常
+func (d *Detector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Baselines []Baseline `json:"baselines"`
+		Recent    []Anomaly  `json:"recent"`
+	}{d.Baselines(), d.Recent()})
+}
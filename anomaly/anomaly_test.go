@@ -0,0 +1,109 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/uber/jaeger-client-go"
+	"net/http/httptest"
+	"opentracing-sample/config"
+	"opentracing-sample/spanhook"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThreshold(t *testing.T) {
+	d := New(Config{Enabled: true, MinDuration: 10 * time.Millisecond})
+	for i := 0; i < 100; i++ {
+		if _, ok := d.Observe("svc", "doSomething1", time.Duration(10+i)*time.Millisecond); ok {
+			t.Fatal("no anomalies before MinSamples")
+		}
+	}
+	// 10ms 到 109ms：中位数 59ms，p99 108ms，MAD 25ms，阈值 108 + 3*25 = 183ms
+	b := d.Baselines()[0]
+	if b.Samples != 100 || b.P50 != 59*time.Millisecond || b.P99 != 108*time.Millisecond || b.MAD != 25*time.Millisecond {
+		t.Fatalf("unexpected baseline %+v", b)
+	}
+	if threshold, ok := d.Observe("svc", "doSomething1", 183*time.Millisecond); ok || threshold != 183*time.Millisecond {
+		t.Fatalf("at the threshold should not be anomalous, threshold %s", threshold)
+	}
+	if _, ok := d.Observe("svc", "doSomething1", 3*time.Second); !ok {
+		t.Fatal("3s should be anomalous")
+	}
+	// 其他操作有自己的基线
+	if _, ok := d.Observe("svc", "doSomething2", 3*time.Second); ok {
+		t.Fatal("a new operation has no baseline yet")
+	}
+
+	if _, ok := Disabled().Observe("svc", "doSomething1", time.Hour); ok {
+		t.Fatal("disabled detector reported an anomaly")
+	}
+}
+
+func TestMinDuration(t *testing.T) {
+	d := New(Config{Enabled: true, MinSamples: 10, MinDuration: 10 * time.Millisecond})
+	for i := 0; i < 10; i++ {
+		d.Observe("svc", "fast", time.Millisecond)
+	}
+	// MAD 为 0，2ms 超过阈值但低于 MinDuration
+	if _, ok := d.Observe("svc", "fast", 2*time.Millisecond); ok {
+		t.Fatal("spans below MinDuration should not be anomalous")
+	}
+	if _, ok := d.Observe("svc", "fast", 20*time.Millisecond); !ok {
+		t.Fatal("20ms should be anomalous")
+	}
+}
+
+func TestHookForcesSampling(t *testing.T) {
+	hook := test.NewLocal(config.Log)
+	defer hook.Reset()
+
+	reporter := jaeger.NewInMemoryReporter()
+	inner, closer := jaeger.NewTracer("svc", jaeger.NewConstSampler(false), reporter)
+	defer closer.Close()
+	d := New(Config{Enabled: true, MinSamples: 10, JaegerUI: "http://jaeger:16686/"})
+	tracer := spanhook.NewTracer(inner, d.Hook("svc"))
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		tracer.StartSpan("doSomething1", opentracing.StartTime(start)).
+			FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(20 * time.Millisecond)})
+	}
+	if n := len(reporter.GetSpans()); n != 0 {
+		t.Fatalf("unsampled spans reported: %d", n)
+	}
+
+	parent := tracer.StartSpan("request")
+	child := tracer.StartSpan("doSomething1", opentracing.ChildOf(parent.Context()), opentracing.StartTime(start))
+	child.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(3 * time.Second)})
+	parent.Finish()
+
+	spans := reporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("anomalous trace not kept, got %d spans", len(spans))
+	}
+	tags := spans[0].(*jaeger.Span).Tags()
+	if tags[TagAnomaly] != true || tags[TagThreshold] != 20.0 {
+		t.Fatalf("anomalous span not tagged: %v", tags)
+	}
+
+	traceID := config.TraceID(child)
+	recent := d.Recent()
+	if len(recent) != 1 || recent[0].Link != "http://jaeger:16686/trace/"+traceID || recent[0].Duration != 3*time.Second {
+		t.Fatalf("unexpected anomalies %+v", recent)
+	}
+	if entry := hook.LastEntry(); entry == nil || !strings.Contains(entry.Message, recent[0].Link) {
+		t.Fatalf("trace link not logged: %v", entry)
+	}
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/anomalies", nil))
+	var body struct {
+		Baselines []Baseline `json:"baselines"`
+		Recent    []Anomaly  `json:"recent"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || len(body.Baselines) != 2 || len(body.Recent) != 1 {
+		t.Fatalf("unexpected response %+v, %v", body, err)
+	}
+}
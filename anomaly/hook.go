@@ -0,0 +1,39 @@
+package anomaly
+
+import (
+	"github.com/opentracing/opentracing-go/ext"
+	"opentracing-sample/config"
+	"opentracing-sample/spanhook"
+	"time"
+)
+
+// Hook 返回交给 spanhook.NewTracer 的钩子，service 是基线中使用的服务名。异常的 span 打上标签并设置
+// sampling.priority，原本没有被采样时也会上报。优先级在 span 结束时才设置，此时已经结束的子 span
+// 已按未采样丢弃，只有这个 span 和同一 trace 中之后结束的 span（通常是它的上游直到根 span）会被保留
+func (d *Detector) Hook(service string) spanhook.Hook {
+	return func(f *spanhook.Finished) {
+		duration := f.Duration()
+		threshold, ok := d.Observe(service, f.Operation, duration)
+		if !ok {
+			return
+		}
+		// 先强制采样，未采样的 span 不会记录之后设置的标签
+		ext.SamplingPriority.Set(f.Span, 1)
+		f.Span.SetTag(TagAnomaly, true)
+		f.Span.SetTag(TagThreshold, millis(threshold))
+		traceID := config.TraceID(f.Span)
+		d.record(Anomaly{
+			Service:   service,
+			Operation: f.Operation,
+			Duration:  duration,
+			Threshold: threshold,
+			TraceID:   traceID,
+			Link:      d.Link(traceID),
+			Time:      f.Finish,
+		})
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,254 @@
+// Package breaker 为下游依赖（gRPC、数据库、缓存、HTTP）提供熔断器。
+// 熔断器打开时请求直接失败，状态变化写入 config.Log 并记录为当前 span 的日志。
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"opentracing-sample/config"
+	"sync"
+	"time"
+)
+
+// ErrOpen 表示熔断器处于打开状态，请求没有发出
+var ErrOpen = errors.New("breaker: open")
+
+// ErrSkipped 传给 done 表示调用最终没有发出（如数据库驱动返回 driver.ErrSkip），
+// 只归还半开时的探测名额，不计入成功或失败
+var ErrSkipped = errors.New("breaker: call skipped")
+
+// State 是熔断器的状态
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Settings 配置熔断器：连续失败 FailureThreshold 次后打开，CoolDown 之后进入半开，
+// 半开时最多放行 HalfOpenRequests 个探测请求，探测成功则关闭，失败则重新打开。
+// IsFailure 判断一次调用的错误是否计为失败，默认使用 IsFailure。
+type Settings struct {
+	FailureThreshold int
+	CoolDown         time.Duration
+	HalfOpenRequests int
+	IsFailure        func(error) bool
+}
+
+// DefaultSettings 连续失败 5 次后打开 10 秒
+var DefaultSettings = Settings{
+	FailureThreshold: 5,
+	CoolDown:         10 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+// IsFailure 把上下文取消和调用方自身导致的 gRPC 错误（参数错误、无权限等）排除在失败之外
+func IsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// 调用方常用 %w 包装 gRPC 错误，status.Code 不会展开
+	var se interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &se) {
+		return true
+	}
+	switch se.GRPCStatus().Code() {
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange:
+		return false
+	}
+	return true
+}
+
+// Breaker 是单个下游依赖的熔断器，可以并发使用
+type Breaker struct {
+	name     string
+	settings Settings
+	now      func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	probes   int
+	changed  time.Time
+	// generation 在每次状态变化时加一，放行之后状态已经变化的调用结果不再计入
+	generation uint64
+}
+
+// New 创建关闭状态的熔断器
+func New(name string, s Settings) *Breaker {
+	return &Breaker{name: name, settings: s.normalize(), now: time.Now, changed: time.Now()}
+}
+
+func (s Settings) normalize() Settings {
+	if s.FailureThreshold < 1 {
+		s.FailureThreshold = 1
+	}
+	if s.HalfOpenRequests < 1 {
+		s.HalfOpenRequests = 1
+	}
+	if s.IsFailure == nil {
+		s.IsFailure = IsFailure
+	}
+	return s
+}
+
+// configure 替换配置，保留当前状态和失败计数
+func (b *Breaker) configure(s Settings) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings = s.normalize()
+}
+
+// Name 返回熔断器保护的下游名字
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State 返回当前状态，打开超过 CoolDown 时返回 HalfOpen
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.changed) >= b.settings.CoolDown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Allow 判断能否发出请求。允许时返回的 done 必须以调用结果调用一次；拒绝时返回包装了 ErrOpen 的错误。
+func (b *Breaker) Allow(ctx context.Context) (done func(error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.changed) >= b.settings.CoolDown {
+		b.setState(ctx, HalfOpen)
+	}
+	switch b.state {
+	case Open:
+		return nil, b.reject(ctx)
+	case HalfOpen:
+		if b.probes >= b.settings.HalfOpenRequests {
+			return nil, b.reject(ctx)
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.done(ctx, generation, err) })
+	}, nil
+}
+
+// Do 在熔断器保护下执行 fn
+func (b *Breaker) Do(ctx context.Context, fn func(context.Context) error) error {
+	done, err := b.Allow(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(ctx)
+	done(err)
+	return err
+}
+
+// done 记录放行时处于 generation 的调用结果。例如关闭时放行的慢请求在熔断器打开再进入半开后才返回，
+// 它不是探测请求，不能占用探测名额，也不能决定半开之后的状态
+func (b *Breaker) done(ctx context.Context, generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := b.settings.IsFailure(err)
+	if generation != b.generation {
+		return
+	}
+	if errors.Is(err, ErrSkipped) {
+		if b.state == HalfOpen {
+			b.probes--
+		}
+		return
+	}
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.setState(ctx, Open)
+		}
+	case HalfOpen:
+		b.probes--
+		if failed {
+			b.setState(ctx, Open)
+		} else {
+			b.setState(ctx, Closed)
+		}
+	}
+}
+
+func (b *Breaker) reject(ctx context.Context) error {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.LogFields(
+			log.String("event", "breaker.rejected"),
+			log.String("breaker", b.name),
+			log.String("state", b.state.String()),
+		)
+	}
+	return fmt.Errorf("%w: %s", ErrOpen, b.name)
+}
+
+// setState 切换状态并记录日志，调用方需持有锁
+func (b *Breaker) setState(ctx context.Context, to State) {
+	from := b.state
+	b.state = to
+	b.changed = b.now()
+	b.probes = 0
+	b.generation++
+	if to == Closed {
+		b.failures = 0
+	}
+
+	config.Log.WithField("component", "breaker").
+		Warnf("breaker %s: %s -> %s", b.name, from, to)
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.LogFields(
+			log.String("event", "breaker.state_change"),
+			log.String("breaker", b.name),
+			log.String("from", from.String()),
+			log.String("to", to.String()),
+		)
+	}
+}
+
+// Status 是熔断器的状态快照
+type Status struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	Since    time.Time `json:"since"`
+}
+
+func (b *Breaker) status() Status {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Status{Name: b.name, State: state.String(), Failures: b.failures, Since: b.changed}
+}
@@ -0,0 +1,184 @@
+package breaker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var errDown = status.Error(codes.Unavailable, "down")
+
+func events(span *mocktracer.MockSpan) []string {
+	var got []string
+	for _, rec := range span.Logs() {
+		for _, f := range rec.Fields {
+			if f.Key == "event" {
+				got = append(got, f.ValueString)
+			}
+		}
+	}
+	return got
+}
+
+func TestStateMachine(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("grpc:localhost:50051", Settings{FailureThreshold: 2, CoolDown: time.Second})
+	b.now = func() time.Time { return now }
+
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("GET /api/product/:id")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	fail := func(context.Context) error { return errDown }
+	ok := func(context.Context) error { return nil }
+
+	b.Do(ctx, fail)
+	if b.State() != Closed {
+		t.Fatal("opened before threshold")
+	}
+	b.Do(ctx, fail)
+	if b.State() != Open {
+		t.Fatal("not opened after threshold")
+	}
+	called := false
+	err := b.Do(ctx, func(context.Context) error { called = true; return nil })
+	if !errors.Is(err, ErrOpen) || called {
+		t.Fatalf("open breaker should fail fast, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatal("not half-open after cool-down")
+	}
+	done, err := b.Allow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Allow(ctx); !errors.Is(err, ErrOpen) {
+		t.Fatal("half-open breaker admitted more than one probe")
+	}
+	done(errDown)
+	if b.State() != Open {
+		t.Fatal("failed probe should reopen")
+	}
+
+	now = now.Add(time.Second)
+	b.Do(ctx, ok)
+	if b.State() != Closed {
+		t.Fatal("successful probe should close")
+	}
+
+	span.Finish()
+	want := []string{"breaker.state_change", "breaker.rejected", "breaker.state_change",
+		"breaker.rejected", "breaker.state_change", "breaker.state_change", "breaker.state_change"}
+	if got := events(tracer.FinishedSpans()[0]); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got span events %v, want %v", got, want)
+	}
+}
+
+func TestStaleResultIgnored(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("db", Settings{FailureThreshold: 1, CoolDown: time.Second})
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// 关闭时放行的慢请求，在熔断器打开并进入半开之后才返回
+	slow, err := b.Allow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Do(ctx, func(context.Context) error { return errDown })
+	now = now.Add(time.Second)
+	probe, err := b.Allow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow(nil)
+	if b.State() != HalfOpen {
+		t.Fatalf("stale success changed the state to %s", b.State())
+	}
+	if _, err := b.Allow(ctx); !errors.Is(err, ErrOpen) {
+		t.Fatal("stale result freed a probe slot")
+	}
+	probe(errDown)
+	if b.State() != Open {
+		t.Fatal("failed probe should reopen")
+	}
+}
+
+func TestSkippedProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("db", Settings{FailureThreshold: 1, CoolDown: time.Second})
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	b.Do(ctx, func(context.Context) error { return errDown })
+	now = now.Add(time.Second)
+	// 没有发出的探测只归还名额，状态不变
+	b.Do(ctx, func(context.Context) error { return ErrSkipped })
+	if b.State() != HalfOpen {
+		t.Fatalf("skipped probe changed the state to %s", b.State())
+	}
+	b.Do(ctx, func(context.Context) error { return nil })
+	if b.State() != Closed {
+		t.Fatal("successful probe should close")
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{errors.New("dial tcp: connection refused"), true},
+		{errDown, true},
+		{status.Error(codes.PermissionDenied, "no"), false},
+		{fmt.Errorf("could not greet: %w", status.Error(codes.PermissionDenied, "no")), false},
+		{fmt.Errorf("could not greet: %w", errDown), true},
+	}
+	for _, c := range cases {
+		if got := IsFailure(c.err); got != c.want {
+			t.Errorf("IsFailure(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(DefaultSettings)
+	r.Configure("redis", Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	if r.Get("mysql") != r.Get("mysql") {
+		t.Fatal("Get should return the same breaker")
+	}
+	r.Get("redis").Do(context.Background(), func(context.Context) error { return errDown })
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/breakers", nil))
+	var got []Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "mysql" || got[0].State != "closed" || got[1].State != "open" {
+		t.Fatalf("unexpected snapshot %+v", got)
+	}
+
+	// 重新配置已经取出的熔断器，持有的指针仍然是注册表中的那个
+	mysql := r.Get("mysql")
+	r.Configure("mysql", Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	if r.Get("mysql") != mysql {
+		t.Fatal("Configure replaced a breaker callers already hold")
+	}
+	mysql.Do(context.Background(), func(context.Context) error { return errDown })
+	if mysql.State() != Open {
+		t.Fatalf("new settings not applied, state %v", mysql.State())
+	}
+}
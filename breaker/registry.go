@@ -0,0 +1,73 @@
+package breaker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Registry 按名字管理熔断器，同名的下游共享一个熔断器
+type Registry struct {
+	mu       sync.Mutex
+	settings Settings
+	custom   map[string]Settings
+	breakers map[string]*Breaker
+}
+
+// NewRegistry 创建以 s 为默认配置的 Registry
+func NewRegistry(s Settings) *Registry {
+	return &Registry{settings: s, custom: map[string]Settings{}, breakers: map[string]*Breaker{}}
+}
+
+// Default 是进程内共享的 Registry
+var Default = NewRegistry(DefaultSettings)
+
+// Configure 为 name 指定单独的配置。已经存在的熔断器就地更新配置，调用方持有的指针继续有效
+func (r *Registry) Configure(name string, s Settings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.custom[name] = s
+	if b, ok := r.breakers[name]; ok {
+		b.configure(s)
+	}
+}
+
+// Get 返回 name 对应的熔断器，不存在时创建
+func (r *Registry) Get(name string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.breakers[name]; ok {
+		return b
+	}
+	s, ok := r.custom[name]
+	if !ok {
+		s = r.settings
+	}
+	b := New(name, s)
+	r.breakers[name] = b
+	return b
+}
+
+// Snapshot 返回所有熔断器的状态，按名字排序
+func (r *Registry) Snapshot() []Status {
+	r.mu.Lock()
+	breakers := make([]*Breaker, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		breakers = append(breakers, b)
+	}
+	r.mu.Unlock()
+
+	statuses := make([]Status, len(breakers))
+	for i, b := range breakers {
+		statuses[i] = b.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// ServeHTTP 以 JSON 输出 Snapshot，用作调试端点
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Snapshot())
+}
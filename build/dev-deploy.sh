@@ -1,6 +1,6 @@
 #!/bin/bash
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o ./bin/gin-sample-tracing ./cmd/gin-sample/main.go
-GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o ./bin/gin-sample-grpc-server ./cmd/grpc-server/main.go
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o ./bin/gin-sample-tracing ./cmd/gin-sample
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o ./bin/gin-sample-grpc-server ./cmd/grpc-server
 
 docker build -f build/Dockerfile-gin-sample -t gin-sample-tracing bin/
 docker build -f build/Dockerfile-grpc-server -t gin-sample-grpc-server bin/
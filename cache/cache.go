@@ -0,0 +1,104 @@
+// Package cache 定义缓存抽象，Redis 实现的每个命令和 pipeline 都会产生 span
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"opentracing-sample/breaker"
+	"time"
+)
+
+// ErrMiss 表示缓存中没有该 key
+var ErrMiss = errors.New("cache: miss")
+
+// Cache 是 cache-aside 场景下需要的最小接口
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+const (
+	TagCommand       = "cache.command"
+	TagKey           = "cache.key"
+	TagHit           = "cache.hit"
+	TagRequestBytes  = "cache.request_bytes"
+	TagResponseBytes = "cache.response_bytes"
+)
+
+type options struct {
+	tracer    func() opentracing.Tracer
+	hashKeys  bool
+	component string
+	breaker   *breaker.Breaker
+}
+
+// Option 配置缓存实现的追踪行为
+type Option func(*options)
+
+// WithTracer 指定 tracer，默认使用 opentracing.GlobalTracer()
+func WithTracer(tracer opentracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = func() opentracing.Tracer { return tracer }
+	}
+}
+
+// WithHashedKeys 在 span 里记录 key 的哈希值而不是原文
+func WithHashedKeys() Option {
+	return func(o *options) {
+		o.hashKeys = true
+	}
+}
+
+// WithBreaker 让命令经过熔断器，打开时直接返回错误，调用方按未命中处理
+func WithBreaker(b *breaker.Breaker) Option {
+	return func(o *options) {
+		o.breaker = b
+	}
+}
+
+func newOptions(component string, opts []Option) *options {
+	o := &options{tracer: opentracing.GlobalTracer, component: component}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) key(key string) string {
+	if !o.hashKeys {
+		return key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// startSpan 只在上下文里已有 span 时创建子 span
+func (o *options) startSpan(ctx context.Context, operation string) opentracing.Span {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	span := o.tracer().StartSpan(operation,
+		opentracing.ChildOf(parent.Context()),
+		opentracing.Tag{Key: string(ext.Component), Value: o.component},
+		ext.SpanKindRPCClient,
+	)
+	ext.DBType.Set(span, o.component)
+	return span
+}
+
+func finish(span opentracing.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && err != ErrMiss {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	span.Finish()
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"opentracing-sample/breaker"
+	"testing"
+	"time"
+)
+
+func testCache(t *testing.T, c Cache, tracer *mocktracer.MockTracer) {
+	parent := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	if _, err := c.Get(ctx, "product:1"); err != ErrMiss {
+		t.Fatalf("expected miss, got %v", err)
+	}
+	if err := c.Set(ctx, "product:1", []byte("hello"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Get(ctx, "product:1")
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	if err := c.Delete(ctx, "product:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "product:1"); err != ErrMiss {
+		t.Fatalf("expected miss after delete, got %v", err)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 5 {
+		t.Fatalf("expected 5 spans, got %d", len(spans))
+	}
+	miss, set, hit := spans[0], spans[1], spans[2]
+	if miss.OperationName != "GET" || miss.Tag(TagHit) != false || miss.Tag("error") != nil {
+		t.Errorf("miss span: %s %v", miss.OperationName, miss.Tags())
+	}
+	if set.Tag(TagRequestBytes) != 5 {
+		t.Errorf("set span: %v", set.Tags())
+	}
+	if hit.Tag(TagHit) != true || hit.Tag(TagResponseBytes) != 5 || hit.Tag(TagKey) != "product:1" {
+		t.Errorf("hit span: %v", hit.Tags())
+	}
+}
+
+func TestMemory(t *testing.T) {
+	tracer := mocktracer.New()
+	testCache(t, NewMemory(WithTracer(tracer)), tracer)
+}
+
+func TestRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+
+	tracer := mocktracer.New()
+	r := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), WithTracer(tracer))
+	testCache(t, r, tracer)
+
+	tracer.Reset()
+	parent := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	pipe := r.Client().WithContext(ctx).Pipeline()
+	pipe.Set("a", "1", 0)
+	pipe.Get("b")
+	if _, err := pipe.Exec(); err != redis.Nil {
+		t.Fatalf("expected redis.Nil from pipeline, got %v", err)
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 || spans[0].OperationName != "PIPELINE" || spans[0].Tag(TagCommand) != "set get" {
+		t.Fatalf("unexpected pipeline spans: %v", spans)
+	}
+	if spans[0].Tag("error") != nil || len(spans[0].Logs()) != 2 {
+		t.Errorf("pipeline span: %v %v", spans[0].Tags(), spans[0].Logs())
+	}
+}
+
+func TestHashedKeys(t *testing.T) {
+	tracer := mocktracer.New()
+	c := NewMemory(WithTracer(tracer), WithHashedKeys())
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("parent"))
+	c.Get(ctx, "user:alice@example.com")
+
+	key := tracer.FinishedSpans()[0].Tag(TagKey).(string)
+	if key == "user:alice@example.com" || len(key) != 16 {
+		t.Fatalf("key not hashed: %q", key)
+	}
+}
+
+func TestRedisBreaker(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := mr.Addr()
+	mr.Close()
+
+	tracer := mocktracer.New()
+	b := breaker.New("redis", breaker.Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	r := NewRedis(redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1}), WithTracer(tracer), WithBreaker(b))
+
+	parent := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	if _, err := r.Get(ctx, "product:1"); err == nil || err == ErrMiss {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+	if _, err := r.Get(ctx, "product:1"); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("expected breaker error, got %v", err)
+	}
+	parent.Finish()
+	// 第一次命令留下 span，被拒绝的命令只在父 span 上记录日志
+	if n := len(tracer.FinishedSpans()); n != 2 {
+		t.Fatalf("expected 2 spans, got %d", n)
+	}
+}
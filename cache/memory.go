@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// Memory 是进程内的缓存实现，主要用于测试
+type Memory struct {
+	mu    sync.Mutex
+	items map[string]entry
+	opts  *options
+	now   func() time.Time
+}
+
+// NewMemory 创建空的内存缓存
+func NewMemory(opts ...Option) *Memory {
+	return &Memory{
+		items: make(map[string]entry),
+		opts:  newOptions("memory", opts),
+		now:   time.Now,
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	span := m.opts.startSpan(ctx, "GET")
+	m.mu.Lock()
+	e, ok := m.items[key]
+	if ok && !e.expireAt.IsZero() && !m.now().Before(e.expireAt) {
+		delete(m.items, key)
+		ok = false
+	}
+	m.mu.Unlock()
+
+	var err error
+	if !ok {
+		err = ErrMiss
+	}
+	if span != nil {
+		span.SetTag(TagCommand, "get")
+		span.SetTag(TagKey, m.opts.key(key))
+		span.SetTag(TagHit, ok)
+		span.SetTag(TagResponseBytes, len(e.value))
+	}
+	finish(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), e.value...), nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	span := m.opts.startSpan(ctx, "SET")
+	e := entry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expireAt = m.now().Add(ttl)
+	}
+	m.mu.Lock()
+	m.items[key] = e
+	m.mu.Unlock()
+
+	if span != nil {
+		span.SetTag(TagCommand, "set")
+		span.SetTag(TagKey, m.opts.key(key))
+		span.SetTag(TagRequestBytes, len(value))
+	}
+	finish(span, nil)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	span := m.opts.startSpan(ctx, "DEL")
+	m.mu.Lock()
+	delete(m.items, key)
+	m.mu.Unlock()
+
+	if span != nil {
+		span.SetTag(TagCommand, "del")
+		span.SetTag(TagKey, m.opts.key(key))
+	}
+	finish(span, nil)
+	return nil
+}
@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v7"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"opentracing-sample/breaker"
+	"strings"
+	"time"
+)
+
+// Redis 是基于 go-redis 的缓存实现
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis 给 client 挂上追踪 hook 并返回缓存实现，client 上的其他命令和 pipeline 同样会被追踪
+func NewRedis(client *redis.Client, opts ...Option) *Redis {
+	o := newOptions("redis", opts)
+	// 熔断 hook 在追踪 hook 之前，被拒绝的命令不会留下未结束的 span
+	if o.breaker != nil {
+		client.AddHook(&breakerHook{breaker: o.breaker})
+	}
+	client.AddHook(&tracingHook{opts: o})
+	return &Redis{client: client}
+}
+
+// Client 返回底层的 redis.Client，用于执行 pipeline 等非 Cache 接口的操作
+func (r *Redis) Client() *redis.Client {
+	return r.client
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := r.client.WithContext(ctx).Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	return b, err
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.WithContext(ctx).Set(key, value, ttl).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.WithContext(ctx).Del(key).Err()
+}
+
+type spanKey struct{}
+
+type doneKey struct{}
+
+// breakerHook 在熔断器打开时拒绝命令，并把命令结果反馈给熔断器
+type breakerHook struct {
+	breaker *breaker.Breaker
+}
+
+func (h *breakerHook) before(ctx context.Context) (context.Context, error) {
+	done, err := h.breaker.Allow(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, doneKey{}, done), nil
+}
+
+func (h *breakerHook) after(ctx context.Context, err error) {
+	if done, ok := ctx.Value(doneKey{}).(func(error)); ok {
+		done(err)
+	}
+}
+
+func (h *breakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.before(ctx)
+}
+
+func (h *breakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.after(ctx, cmdErr(cmd))
+	return nil
+}
+
+func (h *breakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.before(ctx)
+}
+
+func (h *breakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmdErr(cmd); err != nil {
+			break
+		}
+	}
+	h.after(ctx, err)
+	return nil
+}
+
+// tracingHook 为每个命令和 pipeline 创建 span
+type tracingHook struct {
+	opts *options
+}
+
+func (h *tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	span := h.opts.startSpan(ctx, strings.ToUpper(cmd.Name()))
+	if span == nil {
+		return ctx, nil
+	}
+	h.tagCommand(span, cmd)
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+func (h *tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	span, ok := ctx.Value(spanKey{}).(opentracing.Span)
+	if !ok {
+		return nil
+	}
+	h.tagResult(span, cmd)
+	finish(span, cmdErr(cmd))
+	return nil
+}
+
+func (h *tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	span := h.opts.startSpan(ctx, "PIPELINE")
+	if span == nil {
+		return ctx, nil
+	}
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+	span.SetTag(TagCommand, strings.Join(names, " "))
+	span.SetTag("cache.pipeline_size", len(cmds))
+	return context.WithValue(ctx, spanKey{}, span), nil
+}
+
+func (h *tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	span, ok := ctx.Value(spanKey{}).(opentracing.Span)
+	if !ok {
+		return nil
+	}
+	var firstErr error
+	for _, cmd := range cmds {
+		fields := []log.Field{log.String("command", cmd.Name())}
+		if key := cmdKey(cmd); key != "" {
+			fields = append(fields, log.String("key", h.opts.key(key)))
+		}
+		if hit, ok := cmdHit(cmd); ok {
+			fields = append(fields, log.Bool("hit", hit))
+		}
+		if err := cmdErr(cmd); err != nil {
+			fields = append(fields, log.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		span.LogFields(fields...)
+	}
+	finish(span, firstErr)
+	return nil
+}
+
+func (h *tracingHook) tagCommand(span opentracing.Span, cmd redis.Cmder) {
+	span.SetTag(TagCommand, cmd.Name())
+	if key := cmdKey(cmd); key != "" {
+		span.SetTag(TagKey, h.opts.key(key))
+	}
+	if cmd.Name() == "set" && len(cmd.Args()) > 2 {
+		span.SetTag(TagRequestBytes, argSize(cmd.Args()[2]))
+	}
+}
+
+func (h *tracingHook) tagResult(span opentracing.Span, cmd redis.Cmder) {
+	if hit, ok := cmdHit(cmd); ok {
+		span.SetTag(TagHit, hit)
+	}
+	if c, ok := cmd.(*redis.StringCmd); ok && c.Err() == nil {
+		span.SetTag(TagResponseBytes, len(c.Val()))
+	}
+}
+
+// cmdErr 把 redis.Nil 视为未命中而不是错误
+func cmdErr(cmd redis.Cmder) error {
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		return err
+	}
+	return nil
+}
+
+func cmdKey(cmd redis.Cmder) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return ""
+	}
+	return fmt.Sprint(args[1])
+}
+
+func cmdHit(cmd redis.Cmder) (bool, bool) {
+	switch cmd.Name() {
+	case "get", "hget", "getset":
+		return cmd.Err() != redis.Nil, true
+	}
+	return false, false
+}
+
+func argSize(arg interface{}) int {
+	switch v := arg.(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	return len(fmt.Sprint(arg))
+}
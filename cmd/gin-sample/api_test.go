@@ -1,14 +1,68 @@
 package main
 
 import (
+	"context"
 	"github.com/gavv/httpexpect"
+	"github.com/gin-gonic/gin"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
 	"io"
+	_ "modernc.org/sqlite"
 	"net/http"
+	"opentracing-sample/cache"
 	"opentracing-sample/config"
+	"opentracing-sample/depgraph"
+	"opentracing-sample/fault"
+	"opentracing-sample/jobs"
+	"opentracing-sample/product"
+	"opentracing-sample/ratelimit"
+	"opentracing-sample/retry"
+	"opentracing-sample/service/servicetest"
+	"opentracing-sample/slo"
+	"opentracing-sample/spanhook"
+	"opentracing-sample/spanstore"
+	"opentracing-sample/sqltrace"
+	"opentracing-sample/tenant"
+	"os"
+	"strconv"
 	"testing"
+	"time"
 )
 
+// grpcTracer 是进程内 grpc-server 使用的 tracer，测试把它设为全局 tracer 后两端的 span 都记录在这里
+var grpcTracer = mocktracer.New()
+
+// TestMain 在 bufconn 上启动进程内的 grpc-server，测试不再依赖外部服务
+func TestMain(m *testing.M) {
+	tenant.TokenSecret = []byte("test-secret")
+	tenantResolver = newTenantResolver()
+	srv := servicetest.NewServer(grpcTracer)
+	dialOptions = append(dialOptions, srv.DialOption())
+
+	// 用内存缓存代替 redis，纯 Go 的 sqlite 代替 mysql
+	Cache = cache.NewMemory()
+	if DB, err = sqltrace.Open("sqlite", ":memory:"); err != nil {
+		panic(err)
+	}
+	DB.SetMaxOpenConns(1)
+	if err := product.Migrate(context.Background(), DB, "sqlite"); err != nil {
+		panic(err)
+	}
+	Products = product.NewService(product.NewSQLRepository(DB), Cache)
+	if err := Products.Create(context.Background(), &product.Product{Name: "opentracing", Price: 9.9}); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	backgroundJobs.Close()
+	srv.Close()
+	DB.Close()
+	os.Exit(code)
+}
+
 func getHttpExpect(t *testing.T) *httpexpect.Expect {
 
 	return httpexpect.WithConfig(httpexpect.Config{
@@ -34,6 +88,302 @@ func TestProduct(t *testing.T) {
 
 	e := getHttpExpect(t)
 
-	e.GET("/api/product").WithHeader("x-request-id", "2f4b419adf0f50953c5aa47b98941f3e").Expect().Status(200)
-	e.GET("/api/reviews").Expect().Status(200)
+	e.GET("/api/product/1").WithHeader("x-request-id", "2f4b419adf0f50953c5aa47b98941f3e").Expect().Status(200).
+		JSON().Path("$.product.name").Equal("opentracing")
+	e.GET("/api/product/1").Expect().Status(200).JSON().Path("$.product.price").Equal(9.9)
+	e.GET("/api/product/404").Expect().Status(404)
+	e.GET("/api/product/abc").Expect().Status(400)
+	e.GET("/api/product/1/reviews").Expect().Status(200).JSON().Array().Empty()
+
+	legacy := e.GET("/api/reviews").WithQuery("product_id", 1).Expect()
+	legacy.Status(200).JSON().Array().Empty()
+	legacy.Header("Deprecation").Equal("true")
+	legacy.Header("Link").Equal(`</api/product/1/reviews>; rel="successor-version"`)
+	e.GET("/api/reviews").Expect().Status(400)
+}
+
+func TestGRPCTracePropagation(t *testing.T) {
+	grpcTracer.Reset()
+	opentracing.SetGlobalTracer(grpcTracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	getHttpExpect(t).GET("/api/product/1/reviews").Expect().Status(http.StatusOK)
+
+	var client, server *mocktracer.MockSpan
+	for _, s := range grpcTracer.FinishedSpans() {
+		switch {
+		case s.OperationName == "call gRPC":
+			client = s
+		case s.OperationName == "/Greeter/SayHello" && s.Tag(string(ext.SpanKind)) == ext.SpanKindRPCServerEnum:
+			server = s
+		}
+	}
+	if client == nil || server == nil {
+		t.Fatalf("client or server span missing: %v", grpcTracer.FinishedSpans())
+	}
+	// 追踪上下文经过 bufconn 传给了 grpc-server
+	if server.ParentID != client.SpanContext.SpanID || server.SpanContext.TraceID != client.SpanContext.TraceID {
+		t.Fatalf("server span %v is not a child of client span %v", server.SpanContext, client.SpanContext)
+	}
+}
+
+func TestTenant(t *testing.T) {
+	// 租户经 baggage 传给 grpc-server，需要能携带 baggage 的 tracer
+	opentracing.SetGlobalTracer(grpcTracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	e := getHttpExpect(t)
+	token, _ := tenant.SignToken(tenant.Claims{Tenant: "acme"}, tenant.TokenSecret)
+
+	e.GET("/api/product/1").WithHeader("X-Tenant-ID", "acme").
+		WithHeader("Authorization", "Bearer "+token).Expect().Status(200)
+	e.GET("/api/product/1").WithHeader("Authorization", "Bearer "+token).Expect().Status(200)
+	e.GET("/api/product/1").WithHeader("X-Tenant-ID", "globex").
+		WithHeader("Authorization", "Bearer "+token).Expect().Status(http.StatusForbidden)
+	e.GET("/api/product/1").WithHeader("X-Tenant-ID", "acme").Expect().Status(http.StatusForbidden)
+}
+
+func TestProductCRUD(t *testing.T) {
+	e := getHttpExpect(t)
+
+	e.POST("/api/product").WithJSON(map[string]interface{}{"price": 1}).Expect().Status(400)
+	id := e.POST("/api/product").WithJSON(map[string]interface{}{"name": "jaeger", "price": 20}).
+		Expect().Status(201).JSON().Object().Value("id").Number().Raw()
+	path := "/api/product/" + strconv.Itoa(int(id))
+
+	e.PUT(path).WithJSON(map[string]interface{}{"name": "jaeger", "price": 25}).Expect().Status(200)
+	e.GET(path).Expect().Status(200).JSON().Path("$.product.price").Equal(25)
+
+	list := e.GET("/api/product").WithQuery("name", "JAE").WithQuery("limit", 10).Expect().Status(200).JSON().Object()
+	list.Value("total").Equal(1)
+	list.Value("items").Array().First().Object().Value("name").Equal("jaeger")
+
+	e.POST(path + "/reviews").WithJSON(map[string]interface{}{"author": "alice", "rating": 6}).Expect().Status(400)
+	e.POST(path + "/reviews").WithJSON(map[string]interface{}{"author": "alice", "rating": 5}).Expect().Status(201)
+	e.GET(path + "/reviews").Expect().Status(200).JSON().Array().Length().Equal(1)
+
+	e.DELETE(path).Expect().Status(204)
+	e.GET(path).Expect().Status(404)
+	e.DELETE(path).Expect().Status(404)
+}
+
+func TestRecovery(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("gin-sample-tracing", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	r := httpServer()
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	e := httpexpect.WithConfig(httpexpect.Config{
+		Client:   &http.Client{Transport: httpexpect.NewBinder(r)},
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+
+	e.GET("/panic").WithQuery("page", 2).Expect().Status(http.StatusInternalServerError).JSON().Path("$.error").Equal("internal error")
+	spans := reporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %v", spans)
+	}
+	tags := spans[0].(*jaeger.Span).Tags()
+	if tags["error"] != true || tags["http.status_code"] != uint16(http.StatusInternalServerError) {
+		t.Fatalf("panic not recorded on request span: %v", tags)
+	}
+	if tags["http.method"] != "GET" || tags["http.url"] != "/panic?page=2" {
+		t.Fatalf("request not recorded for replay: %v", tags)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter = ratelimit.New(ratelimit.Config{Rules: []ratelimit.Rule{
+		{Name: "reviews-ip", Match: "/api/product/:id/reviews", Key: ratelimit.KeyIP, Rate: 0.001, Burst: 1},
+	}})
+	defer func() { limiter = ratelimit.New(ratelimit.Config{}) }()
+
+	e := getHttpExpect(t)
+	e.GET("/api/product/1/reviews").Expect().Status(http.StatusOK)
+	e.GET("/api/product/1/reviews").Expect().Status(http.StatusTooManyRequests)
+	e.GET("/api/product/1").Expect().Status(http.StatusOK)
+	e.GET("/debug/vars").Expect().Status(http.StatusOK).
+		JSON().Object().Value("ratelimit_rejected").Object().Value("reviews-ip").Number().Ge(1)
+}
+
+func TestCheckTokenRetrySpans(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("gin-sample-tracing", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	getHttpExpect(t).GET("/api/product/1/reviews").Expect().Status(http.StatusOK)
+
+	spans := map[string]*jaeger.Span{}
+	for _, s := range reporter.GetSpans() {
+		spans[s.(*jaeger.Span).OperationName()] = s.(*jaeger.Span)
+	}
+	parent, attempt, call := spans["retry /Greeter/SayHello"], spans["/Greeter/SayHello"], spans["call gRPC"]
+	if parent == nil || attempt == nil || call == nil {
+		t.Fatalf("missing retry spans: %v", spans)
+	}
+	if parent.Tags()[retry.TagOutcome] != retry.OutcomeSuccess || attempt.Tags()[retry.TagAttempt] != 1 {
+		t.Fatalf("unexpected tags: %v %v", parent.Tags(), attempt.Tags())
+	}
+	if attempt.SpanContext().ParentID() != parent.SpanContext().SpanID() ||
+		call.SpanContext().ParentID() != attempt.SpanContext().SpanID() {
+		t.Fatal("attempt spans not nested under the retry span")
+	}
+}
+
+func TestBreakersEndpoint(t *testing.T) {
+	e := getHttpExpect(t)
+	e.GET("/api/product/1/reviews").Expect().Status(http.StatusOK)
+
+	b := e.GET("/debug/breakers").Expect().Status(http.StatusOK).JSON().Array().First().Object()
+	b.Value("name").Equal("grpc:" + address)
+	b.Value("state").Equal("closed")
+}
+
+func TestFaultInjection(t *testing.T) {
+	e := getHttpExpect(t)
+	e.GET("/api/product/1").WithHeader(fault.Header, "abort=503").Expect().Status(http.StatusOK)
+
+	var err error
+	if faults, err = fault.New(fault.Config{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { faults = fault.Disabled() }()
+	e.GET("/api/product/1").WithHeader(fault.Header, "abort=503").Expect().Status(http.StatusServiceUnavailable)
+	e.GET("/api/product/1").Expect().Status(http.StatusOK)
+	// 小于 100 的是 gRPC 状态码，换算成 HTTP 状态码；写不出的状态码被忽略
+	e.GET("/api/product/1").WithHeader(fault.Header, "abort=14").Expect().Status(http.StatusServiceUnavailable)
+	e.GET("/api/product/1").WithHeader(fault.Header, "abort=1000").Expect().Status(http.StatusOK)
+}
+
+func TestProductDetailsFanOut(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("gin-sample-tracing", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	getHttpExpect(t).GET("/api/product/1").Expect().Status(http.StatusOK)
+
+	spans := map[string]*jaeger.Span{}
+	for _, s := range reporter.GetSpans() {
+		spans[s.(*jaeger.Span).OperationName()] = s.(*jaeger.Span)
+	}
+	parent := spans["getProduceDetails"]
+	if parent == nil {
+		t.Fatalf("handler span missing: %v", spans)
+	}
+	for _, name := range []string{"checkToken", "getProduct", "listReviews"} {
+		s := spans[name]
+		if s == nil || s.SpanContext().ParentID() != parent.SpanContext().SpanID() {
+			t.Fatalf("%s is not a child of getProduceDetails: %v", name, spans)
+		}
+	}
+	if spans["retry /Greeter/SayHello"].SpanContext().ParentID() != spans["checkToken"].SpanContext().SpanID() {
+		t.Fatal("gRPC call not parented to the checkToken branch")
+	}
+}
+
+func TestReviewRecomputesRatingInBackground(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("gin-sample-tracing", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	e := getHttpExpect(t)
+	id := e.POST("/api/product").WithJSON(map[string]interface{}{"name": "zipkin", "price": 5}).
+		Expect().Status(201).JSON().Object().Value("id").Number().Raw()
+	path := "/api/product/" + strconv.Itoa(int(id))
+	e.POST(path + "/reviews").WithJSON(map[string]interface{}{"author": "alice", "rating": 4}).Expect().Status(201)
+	e.POST(path + "/reviews").WithJSON(map[string]interface{}{"author": "bob", "rating": 1}).Expect().Status(201)
+
+	var job, handler *jaeger.Span
+	for deadline := time.Now().Add(2 * time.Second); job == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		for _, s := range reporter.GetSpans() {
+			span := s.(*jaeger.Span)
+			switch span.OperationName() {
+			case "job recomputeRating":
+				if span.Tags()[jobs.TagOutcome] == jobs.OutcomeSuccess {
+					job = span
+				}
+			case "createProductReview":
+				handler = span
+			}
+		}
+	}
+	if job == nil || handler == nil {
+		t.Fatalf("background job span missing: %v", reporter.GetSpans())
+	}
+	if refs := job.References(); len(refs) != 1 || refs[0].Type != opentracing.FollowsFromRef ||
+		job.SpanContext().TraceID() != handler.SpanContext().TraceID() {
+		t.Fatalf("job span does not follow from the request: %v", refs)
+	}
+	// 两个任务都执行完后评分才是最终值
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if p, err := Products.Get(context.Background(), int64(id)); err == nil && p.Rating == 2.5 {
+			return
+		}
+	}
+	t.Fatal("rating not recomputed")
+}
+
+func TestDependencyGraph(t *testing.T) {
+	graph := depgraph.New(time.Minute)
+	newTracer := func(service string) (opentracing.Tracer, io.Closer) {
+		return jaeger.NewTracer(service, jaeger.NewConstSampler(true), spanstore.NewReporter(nil, graph))
+	}
+	tracer, closer := newTracer("gin-sample-tracing")
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	// 单独起一个用 auth-api-grpc 上报的 grpc-server，服务端 span 由 grpc_opentracing 创建
+	serverTracer, serverCloser := newTracer("auth-api-grpc")
+	defer serverCloser.Close()
+	srv := servicetest.NewServer(serverTracer)
+	defer srv.Close()
+	saved := dialOptions
+	dialOptions = []grpc.DialOption{srv.DialOption()}
+	defer func() { dialOptions = saved }()
+
+	getHttpExpect(t).GET("/api/product/1").Expect().Status(http.StatusOK)
+
+	edges := map[string]depgraph.Edge{}
+	for _, e := range graph.Edges() {
+		edges[e.From+" -> "+e.To] = e
+	}
+	if e := edges["gin-sample-tracing -> auth-api-grpc"]; e.Calls != 1 || e.Errors != 0 {
+		t.Fatalf("gRPC call not paired: %+v", edges)
+	}
+	if e := edges["gin-sample-tracing -> sqlite"]; e.Calls == 0 {
+		t.Fatalf("database calls missing: %+v", edges)
+	}
+}
+
+func TestSLO(t *testing.T) {
+	saved := objectives
+	objectives = slo.New(slo.Config{Objectives: []slo.Objective{
+		{Name: "product-latency", Service: "gin-sample-tracing", Match: "/api/product/:id", Target: 0.99, Latency: slo.Duration(1500 * time.Millisecond)},
+	}})
+	defer func() { objectives = saved }()
+	// 请求没有被采样，SLO 仍然统计
+	tracer, closer := jaeger.NewTracer("gin-sample-tracing", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(spanhook.NewTracer(tracer, objectives.Hook("gin-sample-tracing")))
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	e := getHttpExpect(t)
+	e.GET("/api/product/1").Expect().Status(http.StatusOK)
+	e.GET("/api/product/1/reviews").Expect().Status(http.StatusOK)
+
+	status := e.GET("/debug/slo").Expect().Status(http.StatusOK).JSON().Array().First().Object()
+	status.Value("name").Equal("product-latency")
+	status.Value("total").Equal(1)
+	status.Value("compliance").Equal(1)
 }
@@ -2,18 +2,39 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"io"
 	"log"
+	"net/http"
+	"opentracing-sample/anomaly"
+	"opentracing-sample/breaker"
 	"opentracing-sample/config"
 	. "opentracing-sample/config"
+	"opentracing-sample/critpath"
+	"opentracing-sample/depgraph"
+	"opentracing-sample/fault"
+	"opentracing-sample/jobs"
+	"opentracing-sample/product"
+	"opentracing-sample/ratelimit"
+	"opentracing-sample/recovery"
+	"opentracing-sample/retry"
 	"opentracing-sample/service"
+	"opentracing-sample/slo"
+	"opentracing-sample/spanhook"
+	"opentracing-sample/spanstore"
+	"opentracing-sample/tenant"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -24,21 +45,62 @@ const (
 )
 
 var (
-	Conn *grpc.ClientConn
-	err  error
+	err error
+
+	// dialOptions 追加到连接 grpc-server 的拨号选项中，测试时用来换成 bufconn
+	dialOptions []grpc.DialOption
+
+	tenantResolver = newTenantResolver()
+
+	// limiter 默认不限流，main 中按 RATELIMIT_CONFIG 替换
+	limiter = ratelimit.New(ratelimit.Config{})
+
+	// faults 默认不注入故障，main 中按 FAULT_INJECTION 替换
+	faults = fault.Disabled()
+
+	// retryPolicy 是调用 grpc-server 的重试策略
+	retryPolicy = retry.DefaultPolicy
+
+	// backgroundJobs 执行请求返回之后的后台任务，例如新增评论后重新计算评分
+	backgroundJobs = jobs.New(jobs.DefaultConfig)
+
+	// spans 保留最近上报的 span，dependencies 是由它们构建的服务依赖图，都在 main 中挂到 reporter 上
+	spans        = spanstore.NewStore(10000)
+	dependencies = depgraph.New(depgraph.DefaultTimeout)
+
+	// objectives 默认没有 SLO，main 中按 SLO_CONFIG 替换
+	objectives = slo.New(slo.Config{})
+
+	// anomalies 默认不检测耗时异常，main 中按 ANOMALY_DETECTION 替换
+	anomalies = anomaly.Disabled()
 )
 
+// newTenantResolver 依次从 X-Tenant-ID 头、令牌和 TENANT_DOMAIN 的子域名中解析租户
+func newTenantResolver() tenant.Resolver {
+	resolvers := []tenant.Resolver{tenant.FromHeader("X-Tenant-ID"), tenant.FromToken(tenant.TokenSecret)}
+	if domain := os.Getenv("TENANT_DOMAIN"); domain != "" {
+		resolvers = append(resolvers, tenant.FromSubdomain(domain))
+	}
+	return tenant.Chain(resolvers...)
+}
+
+// ClientInterceptor 为每次调用创建客户端 span 并注入 metadata，上下文中有 span（如重试的单次尝试）时以它为父节点
 func ClientInterceptor(c *gin.Context, tracer opentracing.Tracer, spanContext opentracing.SpanContext) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string,
 		req, reply interface{}, cc *grpc.ClientConn,
 		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 
+		if sp := opentracing.SpanFromContext(ctx); sp != nil {
+			spanContext = sp.Context()
+		}
 		span := opentracing.StartSpan(
 			"call gRPC",
 			opentracing.ChildOf(spanContext),
 			opentracing.Tag{Key: string(ext.Component), Value: "gRPC"},
 			ext.SpanKindRPCClient,
 		)
+		// 对端没有上报服务端 span 时，依赖图用地址标识对端
+		ext.PeerAddress.Set(span, cc.Target())
 
 		defer span.Finish()
 
@@ -49,45 +111,67 @@ func ClientInterceptor(c *gin.Context, tracer opentracing.Tracer, spanContext op
 			md = md.Copy()
 		}
 
+		// 在注入追踪头之前记录 metadata，避免把 uber-trace-id 等也记录下来
+		Capture.TagRequestMetadata(span, md)
+
 		// 在客户端拦截器中把 span 注入进去`
-		err := tracer.Inject(span.Context(), opentracing.TextMap, MDReaderWriter{md})
+		TagBaggage(span)
+		err := tracer.Inject(span.Context(), opentracing.TextMap, &BaggageWriter{TextMapWriter: MDReaderWriter{MD: md}})
 		if err != nil {
-			panic(err)
+			return err
 		}
 
+		var header metadata.MD
 		newCtx := metadata.NewOutgoingContext(ctx, md)
-		err = invoker(newCtx, method, req, reply, cc, opts...)
+		err = invoker(newCtx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+		Capture.TagResponseMetadata(span, header)
 		if err != nil {
-			panic(err)
+			ext.Error.Set(span, true)
+			span.SetTag("error.message", err.Error())
 		}
 		return err
 	}
 }
 
-func ConnectgRPCServer(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+// ConnectgRPCServer 连接 grpc-server，调用经过重试拦截器和追踪拦截器，用完需要关闭返回的连接
+func ConnectgRPCServer(ctx context.Context, c *gin.Context) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*500)
 	defer cancel()
 
-	parentSpanContext, _ := c.Get("parentSpanCtx")
-	Conn, err = grpc.DialContext(ctx, address, grpc.WithInsecure(), grpc.WithBlock(),
-		grpc.WithUnaryInterceptor(ClientInterceptor(c, opentracing.GlobalTracer(), parentSpanContext.(opentracing.SpanContext))))
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-	}
+	// baggage 可能在 TracerWrapper 之后才设置，所以取当前 span 的上下文而不是 parentSpanCtx
+	parentSpanContext := opentracing.SpanFromContext(ctx).Context()
+	opts := append([]grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithChainUnaryInterceptor(
+			retry.UnaryClientInterceptor(retryPolicy),
+			ClientInterceptor(c, opentracing.GlobalTracer(), parentSpanContext),
+		)},
+		dialOptions...)
+	return grpc.DialContext(ctx, address, opts...)
 }
 
 func TracerWrapper(c *gin.Context) {
 	//spanCtx, _ := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(c.Request.Header))
-	spanCtx, err := ZipkinPropagator.Extract(opentracing.HTTPHeadersCarrier(c.Request.Header))
+	spanCtx, err := ZipkinPropagator.Extract(BaggageReader{TextMapReader: opentracing.HTTPHeadersCarrier(c.Request.Header)})
 	if err != nil {
 		Log.Error(err)
 	}
-	sp := opentracing.GlobalTracer().StartSpan(c.Request.URL.Path, opentracing.ChildOf(spanCtx))
+	operation := c.FullPath()
+	if operation == "" {
+		operation = c.Request.URL.Path
+	}
+	opts := []opentracing.StartSpanOption{ext.SpanKindRPCServer}
+	// 没有上游时 spanCtx 是空的 jaeger 上下文，不能交给其他实现的 tracer
+	if err == nil {
+		opts = append(opts, opentracing.ChildOf(spanCtx))
+	}
+	sp := opentracing.GlobalTracer().StartSpan(operation, opts...)
+	TagBaggage(sp)
 
 	defer sp.Finish()
 
 	//head:map[Accept:[text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.9] Accept-Encoding:[gzip, deflate] Accept-Language:[zh-CN,zh;q=0.9,en;q=0.8,zh-TW;q=0.7,ja;q=0.6] Content-Length:[0] Cookie:[sidebar_collapsed=false; screenResolution=1536x864; _gitlab_session=e67f65e588be2730a3006cdae744e8a1] Dnt:[1] Upgrade-Insecure-Requests:[1] User-Agent:[Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36] X-B3-Sampled:[1] X-B3-Spanid:[3c5aa47b98941f3e] X-B3-Traceid:[2f4b419adf0f50953c5aa47b98941f3e] X-Envoy-Decorator-Operation:[gin-sample-tracing.istio-sample.svc.cluster.local:80/api/product] X-Envoy-Internal:[true] X-Envoy-Peer-Metadata:[ChoKCkNMVVNURVJfSUQSDBoKS3ViZXJuZXRlcwodCgxJTlNUQU5DRV9JUFMSDRoLMTcyLjE3LjAuMTIKlgIKBkxBQkVMUxKLAiqIAgodCgNhcHASFhoUaXN0aW8taW5ncmVzc2dhdGV3YXkKEwoFY2hhcnQSChoIZ2F0ZXdheXMKFAoIaGVyaXRhZ2USCBoGVGlsbGVyChkKBWlzdGlvEhAaDmluZ3Jlc3NnYXRld2F5CiEKEXBvZC10ZW1wbGF0ZS1oYXNoEgwaCjg0NWNjYzU5OTkKEgoHcmVsZWFzZRIHGgVpc3Rpbwo5Ch9zZXJ2aWNlLmlzdGlvLmlvL2Nhbm9uaWNhbC1uYW1lEhYaFGlzdGlvLWluZ3Jlc3NnYXRld2F5Ci8KI3NlcnZpY2UuaXN0aW8uaW8vY2Fub25pY2FsLXJldmlzaW9uEggaBmxhdGVzdAoaCgdNRVNIX0lEEg8aDWNsdXN0ZXIubG9jYWwKLwoETkFNRRInGiVpc3Rpby1pbmdyZXNzZ2F0ZXdheS04NDVjY2M1OTk5LWRwam05ChsKCU5BTUVTUEFDRRIOGgxpc3Rpby1zeXN0ZW0KXQoFT1dORVISVBpSa3ViZXJuZXRlczovL2FwaXMvYXBwcy92MS9uYW1lc3BhY2VzL2lzdGlvLXN5c3RlbS9kZXBsb3ltZW50cy9pc3Rpby1pbmdyZXNzZ2F0ZXdheQo5Cg9TRVJWSUNFX0FDQ09VTlQSJhokaXN0aW8taW5ncmVzc2dhdGV3YXktc2VydmljZS1hY2NvdW50CicKDVdPUktMT0FEX05BTUUSFhoUaXN0aW8taW5ncmVzc2dhdGV3YXk=] X-Envoy-Peer-Metadata-Id:[router~172.17.0.12~istio-ingressgateway-845ccc5999-dpjm9.istio-system~istio-system.svc.cluster.local] X-Forwarded-For:[172.17.0.1] X-Forwarded-Proto:[http] X-Request-Id:[ca10652c-7872-9c7a-83dc-15a735ace717]]
-	log.Printf("head:%+v", c.Request.Header)
+	// 只记录 Capture 中配置的请求头，值已按策略遮盖并截断
+	Capture.TagRequestHeaders(sp, c.Request.Header)
 
 	//err = ZipkinPropagator.Inject(spanCtx,
 	//	opentracing.HTTPHeadersCarrier(c.Request.Header))
@@ -102,13 +186,9 @@ func TracerWrapper(c *gin.Context) {
 		panic(err)
 	}
 
-	//statusCode := c.Writer.Status()
-	//ext.HTTPStatusCode.Set(sp, uint16(statusCode))
-	//ext.HTTPMethod.Set(sp, c.Request.Method)
-	//ext.HTTPUrl.Set(sp, c.Request.URL.EscapedPath())
-	//if statusCode >= http.StatusInternalServerError {
-	//	ext.Error.Set(sp, true)
-	//}
+	// 记录实际请求的路径和查询参数，replay 据此重放请求；查询参数中的敏感值由 TraceInit 的 redact 遮盖
+	ext.HTTPMethod.Set(sp, c.Request.Method)
+	ext.HTTPUrl.Set(sp, c.Request.URL.RequestURI())
 
 	//ctx := context.TODO()
 	//ctx = opentracing.ContextWithSpan(ctx, sp)
@@ -121,111 +201,221 @@ func TracerWrapper(c *gin.Context) {
 	}
 	c.Set("x-request-id", requestID)
 	c.Set("parentSpanCtx", sp.Context())
-	c.Set("ctx", opentracing.ContextWithSpan(context.Background(), sp))
+	// 以请求的上下文为基础，客户端断开时下游调用随之取消
+	c.Set("ctx", opentracing.ContextWithSpan(c.Request.Context(), sp))
 
 	c.Next()
+	Capture.TagResponseHeaders(sp, c.Writer.Header())
+	ext.HTTPStatusCode.Set(sp, uint16(c.Writer.Status()))
 }
 
-func httpServer() *gin.Engine {
-	r := gin.Default()
-	r.Use(TracerWrapper)
-	//r.Use(ginzap.Ginzap(zap.L(), time.RFC3339, true))8001
-	//r.Use(ginzap.RecoveryWithZap(zap.L(), true))
-	r.GET("/api/product", getProduceDetails)
-	r.GET("/api/reviews", getProductReviews)
-	return r
+// RecoveryWrapper 恢复处理函数的 panic，记录到请求 span 上并返回 500，需要放在 TracerWrapper 之后
+func RecoveryWrapper(c *gin.Context) {
+	defer func() {
+		if p := recover(); p != nil {
+			ctx, ok := c.Value("ctx").(context.Context)
+			if !ok {
+				ctx = context.Background()
+			}
+			recovery.Record(ctx, "http", p)
+			if span := opentracing.SpanFromContext(ctx); span != nil {
+				ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		}
+	}()
+	c.Next()
 }
 
-func getProduceDetails(c *gin.Context) {
-	var XRequestID string
-	value, exists := c.Get("x-request-id")
-	if exists {
-		XRequestID = value.(string)
-	}
-
-	config.Log.WithField("x-request-id", XRequestID).Info("获取产品信息")
-	config.Log.WithField("x-request-id", XRequestID).Info("检查令牌")
-	checkToken(c)
-	config.Log.WithField("x-request-id", XRequestID).Info("令牌检查成功")
+// TenantWrapper 解析请求的租户，写入上下文、baggage 和请求 span 的标签，需要放在 TracerWrapper 之后
+func TenantWrapper(c *gin.Context) {
 	psc, _ := c.Get("ctx")
 	ctx := psc.(context.Context)
 
-	config.Log.WithField("x-request-id", XRequestID).Info("读取redis")
-	doSomething1(c, ctx)
-	config.Log.WithField("x-request-id", XRequestID).Info("读取redis成功，读取mysql")
-	doSomething2(c, ctx)
-	config.Log.WithField("x-request-id", XRequestID).Info("读取mysql成功")
+	t := tenantResolver.Resolve(c.Request)
+	if t == "" {
+		// 上游服务已经通过 baggage 传过来了
+		t = GetBaggage(ctx, BaggageTenant)
+	}
+	if t == "" {
+		c.Next()
+		return
+	}
+
+	ctx = tenant.NewContext(ctx, t)
+	SetBaggage(ctx, BaggageTenant, t)
+	opentracing.SpanFromContext(ctx).SetTag(tenant.Tag, t)
+	c.Set("tenant", t)
+	c.Set("ctx", ctx)
 
-	c.String(200, XRequestID)
+	c.Next()
 }
 
-func getProductReviews(c *gin.Context) {
+// RateLimitWrapper 按路由和客户端限流，延迟升高时丢弃请求，需要放在 TenantWrapper 之后
+func RateLimitWrapper(c *gin.Context) {
 	psc, _ := c.Get("ctx")
-	ctx := psc.(context.Context)
-	reqSpan, _ := opentracing.StartSpanFromContext(ctx, "getProduceDetails")
-	defer reqSpan.Finish()
-
-	//spanContext := reqSpan.Context().(jaeger.SpanContext)
-	//log.Println(spanContext.TraceID())
-	//log.Println(spanContext.SpanID())
-	checkToken(c)
+	span := opentracing.SpanFromContext(psc.(context.Context))
 
-	if value, exists := c.Get("x-request-id"); exists {
-		c.String(200, value.(string))
+	keys := ratelimit.Keys{
+		ratelimit.KeyIP:     c.ClientIP(),
+		ratelimit.KeyToken:  c.GetHeader("Authorization"),
+		ratelimit.KeyTenant: c.GetString("tenant"),
 	}
-}
-
-func doSomething1(c *gin.Context, ctx context.Context) {
-	var XRequestID string
-	value, exists := c.Get("x-request-id")
-	if exists {
-		XRequestID = value.(string)
+	if name, ok := limiter.Allow(c.FullPath(), keys); !ok {
+		ratelimit.Reject(span, name)
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limited"})
+		return
 	}
+	release, ok := limiter.Acquire()
+	if !ok {
+		ratelimit.Reject(span, ratelimit.ConcurrencyLimiter)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server overloaded"})
+		return
+	}
+	defer release()
 
-	config.Log.WithField("x-request-id", XRequestID).Info("连接redis成功,开始读取数据")
-	span, _ := opentracing.StartSpanFromContext(ctx, "doSomething1 (进程内)")
-	defer span.Finish()
-	time.Sleep(time.Second)
-	fmt.Println("pong")
+	c.Next()
 }
 
-func doSomething2(c *gin.Context, ctx context.Context) {
-	var XRequestID string
-	value, exists := c.Get("x-request-id")
-	if exists {
-		XRequestID = value.(string)
+// FaultWrapper 在启用了故障注入时按 x-fault 头、fault baggage 或规则注入故障，需要放在 TenantWrapper 之后。
+// drop 不返回任何响应，直到客户端放弃。
+func FaultWrapper(c *gin.Context) {
+	psc, _ := c.Get("ctx")
+	ctx := psc.(context.Context)
+	f, source, ok := faults.Resolve(ctx, c.FullPath(), c.GetHeader(fault.Header))
+	if !ok {
+		c.Next()
+		return
+	}
+	span := opentracing.SpanFromContext(ctx)
+	fault.Tag(span, f, source)
+
+	switch {
+	case !f.Sleep(c.Request.Context()) || f.Drop:
+		<-c.Request.Context().Done()
+		c.Abort()
+	case f.Abort != 0:
+		ext.HTTPStatusCode.Set(span, uint16(f.HTTPStatus()))
+		c.AbortWithStatusJSON(f.HTTPStatus(), gin.H{"error": "fault injected"})
+	default:
+		c.Next()
 	}
-
-	config.Log.WithField("x-request-id", XRequestID).Info("连接mysql成功,开始读取数据")
-	span, _ := opentracing.StartSpanFromContext(ctx, "doSomething2 (进程内)")
-	defer span.Finish()
-	time.Sleep(time.Second)
-	fmt.Println("pong")
 }
 
-func checkToken(c *gin.Context) context.Context {
-	ConnectgRPCServer(c)
-	client := service.NewGreeterClient(Conn)
-	defer Conn.Close()
+func httpServer() *gin.Engine {
+	r := gin.New()
+	// gin.Recovery 只兜底 TracerWrapper 自身的 panic，之后的 panic 由 RecoveryWrapper 记录到 span 上
+	r.Use(gin.Logger(), gin.Recovery(), TracerWrapper, RecoveryWrapper, TenantWrapper, RateLimitWrapper, FaultWrapper)
+	//r.Use(ginzap.Ginzap(zap.L(), time.RFC3339, true))8001
+	//r.Use(ginzap.RecoveryWithZap(zap.L(), true))
+	productRoutes(r)
+	// 限流、panic 等计数器
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	r.GET("/debug/breakers", gin.WrapH(breaker.Default))
+	r.GET("/debug/jobs", gin.WrapH(backgroundJobs))
+	r.GET("/debug/spans", gin.WrapH(spans))
+	r.GET("/debug/dependencies", gin.WrapH(dependencies))
+	r.GET("/debug/critical-path", gin.WrapH(critpath.Handler(spans)))
+	r.GET("/debug/slo", gin.WrapH(objectives))
+	r.GET("/debug/anomalies", gin.WrapH(anomalies))
+	return r
+}
 
-	name := defaultName
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+// checkToken 调用 grpc-server 校验令牌，span 以 ctx 中的 span 为父节点，所有重试受 ctx 的截止时间约束。
+// grpc-server 不可用时熔断器打开，之后的请求直接失败，不再等待拨号超时。
+func checkToken(ctx context.Context, c *gin.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
-	r, err := client.SayHello(ctx, &service.HelloRequest{Name: name})
-	if err != nil {
-		log.Fatalf("could not greet: %v", err)
-	}
-	log.Printf("Greeting: %s", r.GetMessage())
 
-	return ctx
+	return breaker.Default.Get("grpc:"+address).Do(ctx, func(ctx context.Context) error {
+		conn, err := ConnectgRPCServer(ctx, c)
+		if err != nil {
+			return fmt.Errorf("did not connect: %w", err)
+		}
+		defer conn.Close()
+		client := service.NewGreeterClient(conn)
+
+		name := defaultName
+		// 把调用方的令牌带给 grpc-server，用于校验租户
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", auth)
+		}
+		r, err := client.SayHello(ctx, &service.HelloRequest{Name: name})
+		if err != nil {
+			return fmt.Errorf("could not greet: %w", err)
+		}
+		requestLog(c).Infof("Greeting: %s", r.GetMessage())
+		return nil
+	})
 }
 
 func main() {
+	sampler, err := tenant.SamplerFromEnv()
+	if err != nil {
+		log.Fatalf("invalid sampling config: %v", err)
+	}
+	if objectives, err = slo.FromEnv(); err != nil {
+		log.Fatalf("invalid SLO config: %v", err)
+	}
+	sinks := []spanstore.Sink{spans, dependencies}
+	// SPAN_FILE 指定时把 span 另存为 JSON 行，供 tracediff 等工具离线分析
+	if path := os.Getenv("SPAN_FILE"); path != "" {
+		file, err := spanstore.OpenFile(path)
+		if err != nil {
+			log.Fatalf("could not open span file: %v", err)
+		}
+		defer file.Close()
+		sinks = append(sinks, file)
+	}
+	config.ReporterDecorators = append(config.ReporterDecorators, func(r jaeger.Reporter) jaeger.Reporter {
+		return spanstore.NewReporter(r, sinks...)
+	})
 	var closer io.Closer
-	tracer, closer := config.TraceInit("gin-sample-tracing")
+	tracer, closer := config.TraceInit("gin-sample-tracing", jaegercfg.Sampler(sampler))
 	defer closer.Close()
-	opentracing.SetGlobalTracer(tracer)
+	if anomalies, err = anomaly.FromEnv(); err != nil {
+		log.Fatalf("invalid anomaly detection config: %v", err)
+	}
+	// SLO 和异常检测在 tracer 中统计，未采样的请求也计入
+	tracer = spanhook.NewTracer(tracer, objectives.Hook("gin-sample-tracing"), anomalies.Hook("gin-sample-tracing"))
+	opentracing.SetGlobalTracer(tenant.NewTracer(tracer))
+
+	if limiter, err = ratelimit.FromEnv(); err != nil {
+		log.Fatalf("invalid rate limit config: %v", err)
+	}
+
+	if faults, err = fault.FromEnv(); err != nil {
+		log.Fatalf("invalid fault injection config: %v", err)
+	}
 
-	r := httpServer()
-	r.Run()
+	DB, err = ConnectDB()
+	if err != nil {
+		log.Fatalf("could not open db: %v", err)
+	}
+	defer DB.Close()
+	Cache = ConnectCache()
+	Products = product.NewService(product.NewSQLRepository(DB), Cache)
+
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	srv := &http.Server{Addr: addr, Handler: httpServer()}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("could not serve: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	// 先等进行中的请求结束，它们可能还会入队后台任务；再等已入队的任务执行完，最后才关闭数据库和 tracer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		Log.Warnf("could not shut down http server: %v", err)
+	}
+	backgroundJobs.Close()
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v7"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/url"
+	"opentracing-sample/breaker"
+	"opentracing-sample/cache"
+	"opentracing-sample/config"
+	"opentracing-sample/group"
+	"opentracing-sample/product"
+	"opentracing-sample/sqltrace"
+	"os"
+	"strconv"
+)
+
+var (
+	DB       *sql.DB
+	Cache    cache.Cache
+	Products *product.Service
+)
+
+// ConnectDB 用带追踪的 mysql 驱动打开数据库并建表，DSN 取自 MYSQL_DSN
+func ConnectDB() (*sql.DB, error) {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		dsn = "root:root@tcp(127.0.0.1:3306)/product?parseTime=true"
+		//dsn = "root:root@tcp(mysql:3306)/product?parseTime=true"
+	}
+	db, err := sqltrace.Open("mysql", dsn, sqltrace.WithInstance("product"),
+		sqltrace.WithBreaker(breaker.Default.Get("mysql")))
+	if err != nil {
+		return nil, err
+	}
+	if err := product.Migrate(context.Background(), db, "mysql"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// ConnectCache 连接 redis，地址取自 REDIS_ADDR
+func ConnectCache() cache.Cache {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:6379"
+		//addr = "redis:6379"
+	}
+	return cache.NewRedis(redis.NewClient(&redis.Options{Addr: addr}), cache.WithBreaker(breaker.Default.Get("redis")))
+}
+
+func productRoutes(r gin.IRouter) {
+	g := r.Group("/api/product")
+	g.GET("", listProducts)
+	g.GET("/:id", getProduceDetails)
+	g.GET("/:id/reviews", getProductReviews)
+
+	w := g.Group("", tokenRequired)
+	w.POST("", createProduct)
+	w.PUT("/:id", updateProduct)
+	w.DELETE("/:id", deleteProduct)
+	w.POST("/:id/reviews", createProductReview)
+
+	// /api/reviews 是旧的公开接口，保留为 /api/product/:id/reviews 的别名
+	r.GET("/api/reviews", deprecatedReviews)
+}
+
+// deprecatedReviews 从 product_id 参数取商品 ID 转给 getProductReviews，响应头指向新的路由
+func deprecatedReviews(c *gin.Context) {
+	id := c.Query("product_id")
+	c.Header("Deprecation", "true")
+	c.Header("Link", "</api/product/"+url.PathEscape(id)+"/reviews>; rel=\"successor-version\"")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: id})
+	getProductReviews(c)
+}
+
+func requestLog(c *gin.Context) *logrus.Entry {
+	entry := config.Log.WithField("x-request-id", c.GetString("x-request-id"))
+	if ctx, ok := c.Value("ctx").(context.Context); ok {
+		entry = entry.WithFields(config.BaggageFields(ctx))
+	}
+	return entry
+}
+
+// handlerSpan 在请求 span 下为处理函数创建子 span
+func handlerSpan(c *gin.Context, operation string) (opentracing.Span, context.Context) {
+	psc, _ := c.Get("ctx")
+	return opentracing.StartSpanFromContext(psc.(context.Context), operation)
+}
+
+func tokenRequired(c *gin.Context) {
+	psc, _ := c.Get("ctx")
+	if err := checkToken(psc.(context.Context), c); err != nil {
+		abortTokenCheck(c, err)
+		return
+	}
+	c.Next()
+}
+
+// abortTokenCheck 把令牌校验失败转成响应：grpc-server 拒绝的返回 403，熔断的返回 503，其他返回 502
+func abortTokenCheck(c *gin.Context, err error) {
+	requestLog(c).Error(err)
+	switch {
+	case status.Code(errors.Unwrap(err)) == codes.PermissionDenied:
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+	case errors.Is(err, breaker.ErrOpen):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "token service unavailable"})
+	default:
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "token check failed"})
+	}
+}
+
+func paramID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return 0, false
+	}
+	return id, true
+}
+
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, product.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, product.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		requestLog(c).Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func listProducts(c *gin.Context) {
+	span, ctx := handlerSpan(c, "listProducts")
+	defer span.Finish()
+
+	var q struct {
+		Name     string  `form:"name"`
+		MinPrice float64 `form:"min_price"`
+		MaxPrice float64 `form:"max_price"`
+		Offset   int     `form:"offset"`
+		Limit    int     `form:"limit"`
+	}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := Products.List(ctx, product.Filter{
+		Name: q.Name, MinPrice: q.MinPrice, MaxPrice: q.MaxPrice, Offset: q.Offset, Limit: q.Limit,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func getProduceDetails(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	span, ctx := handlerSpan(c, "getProduceDetails")
+	defer span.Finish()
+
+	requestLog(c).Info("获取产品信息")
+	// 令牌检查、产品和评论互不依赖，并行执行
+	var (
+		p        *product.Product
+		reviews  []product.Review
+		tokenErr error
+	)
+	g, _ := group.WithContext(ctx)
+	g.Go("checkToken", func(ctx context.Context) error {
+		tokenErr = checkToken(ctx, c)
+		return tokenErr
+	})
+	g.Go("getProduct", func(ctx context.Context) (err error) {
+		p, err = Products.Get(ctx, id)
+		return err
+	})
+	g.Go("listReviews", func(ctx context.Context) (err error) {
+		reviews, err = Products.ListReviews(ctx, id)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		if err == tokenErr {
+			abortTokenCheck(c, err)
+		} else {
+			abortWithError(c, err)
+		}
+		return
+	}
+	requestLog(c).Info("令牌检查成功")
+	c.JSON(http.StatusOK, gin.H{"product": p, "reviews": reviews})
+}
+
+func createProduct(c *gin.Context) {
+	span, ctx := handlerSpan(c, "createProduct")
+	defer span.Finish()
+
+	var p product.Product
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = 0
+	if err := Products.Create(ctx, &p); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+func updateProduct(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	span, ctx := handlerSpan(c, "updateProduct")
+	defer span.Finish()
+
+	var p product.Product
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.ID = id
+	if err := Products.Update(ctx, &p); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+func deleteProduct(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	span, ctx := handlerSpan(c, "deleteProduct")
+	defer span.Finish()
+
+	if err := Products.Delete(ctx, id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func getProductReviews(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	span, ctx := handlerSpan(c, "getProductReviews")
+	defer span.Finish()
+
+	if err := checkToken(ctx, c); err != nil {
+		abortTokenCheck(c, err)
+		return
+	}
+	reviews, err := Products.ListReviews(ctx, id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reviews)
+}
+
+func createProductReview(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	span, ctx := handlerSpan(c, "createProductReview")
+	defer span.Finish()
+
+	var r product.Review
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	r.ID = 0
+	r.ProductID = id
+	if err := Products.AddReview(ctx, &r); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+
+	// 写出响应之后再把评分的重新计算交给后台任务，响应可能仍在缓冲中，任务与客户端收到响应的先后不确定。
+	// 入队失败只影响评分的及时性
+	err := backgroundJobs.Enqueue(ctx, "recomputeRating", func(ctx context.Context) error {
+		_, err := Products.RecomputeRating(ctx, id)
+		return err
+	})
+	if err != nil {
+		requestLog(c).Warnf("could not enqueue rating recompute: %v", err)
+	}
+}
@@ -6,34 +6,27 @@ package main
 import (
 	"context"
 	"fmt"
-	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
-	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"io"
 	"log"
 	"net"
+	"opentracing-sample/anomaly"
 	. "opentracing-sample/config"
+	"opentracing-sample/fault"
+	"opentracing-sample/ratelimit"
 	"opentracing-sample/service"
+	"opentracing-sample/spanhook"
+	"opentracing-sample/tenant"
 )
 
 const (
 	port = ":50051"
 )
 
-// server is used to implement helloworld.GreeterServer.
-type server struct {
-	service.UnimplementedGreeterServer
-}
-
-// SayHello implements helloworld.GreeterServer
-func (s *server) SayHello(ctx context.Context, in *service.HelloRequest) (*service.HelloReply, error) {
-	log.Printf("Received: %v", in.GetName())
-	return &service.HelloReply{Message: "Hello " + in.GetName()}, nil
-}
-
 func ServerInterceptor(tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (
 		resp interface{}, err error) {
@@ -42,7 +35,7 @@ func ServerInterceptor(tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
 			md = metadata.New(nil)
 		}
 		// 服务端拦截器则是在MD中把 span提取出来
-		spanContext, err := tracer.Extract(opentracing.TextMap, MDReaderWriter{md})
+		spanContext, err := tracer.Extract(opentracing.TextMap, MDReaderWriter{MD: md})
 		if err != nil && err != opentracing.ErrSpanContextNotFound {
 			fmt.Print("extract from metadata error: ", err)
 		} else {
@@ -60,9 +53,18 @@ func ServerInterceptor(tracer opentracing.Tracer) grpc.UnaryServerInterceptor {
 }
 
 func main() {
+	sampler, err := tenant.SamplerFromEnv()
+	if err != nil {
+		log.Fatalf("invalid sampling config: %v", err)
+	}
+	anomalies, err := anomaly.FromEnv()
+	if err != nil {
+		log.Fatalf("invalid anomaly detection config: %v", err)
+	}
 	var closer io.Closer
-	tracer, closer := TraceInit("auth-api-grpc")
+	tracer, closer := TraceInit("auth-api-grpc", jaegercfg.Sampler(sampler))
 	defer closer.Close()
+	tracer = tenant.NewTracer(spanhook.NewTracer(tracer, anomalies.Hook("auth-api-grpc")))
 	opentracing.SetGlobalTracer(tracer)
 
 	lis, err := net.Listen("tcp", port)
@@ -74,18 +76,15 @@ func main() {
 	//		ServerInterceptor(opentracing.GlobalTracer()),
 	//	)),)
 
-	s := grpc.NewServer(
-		grpc.StreamInterceptor(grpcMiddleware.ChainStreamServer(
-			// add opentracing stream interceptor to chain
-			grpc_opentracing.StreamServerInterceptor(grpc_opentracing.WithTracer(tracer)),
-		)),
-		grpc.UnaryInterceptor(grpcMiddleware.ChainUnaryServer(
-			// add opentracing unary interceptor to chain
-			grpc_opentracing.UnaryServerInterceptor(grpc_opentracing.WithTracer(tracer)),
-		)),
-	)
-
-	service.RegisterGreeterServer(s, &server{})
+	limiter, err := ratelimit.FromEnv()
+	if err != nil {
+		log.Fatalf("invalid rate limit config: %v", err)
+	}
+	faults, err := fault.FromEnv()
+	if err != nil {
+		log.Fatalf("invalid fault injection config: %v", err)
+	}
+	s := service.NewServer(tracer, service.WithLimiter(limiter), service.WithFaults(faults))
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
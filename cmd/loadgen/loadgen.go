@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"io"
+	"math/rand"
+	"net/http"
+	"opentracing-sample/httpclient"
+	"opentracing-sample/spanstore"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request 是请求组合中的一项，按 Weight 的比例发送
+type Request struct {
+	Weight int
+	Method string
+	Path   string
+	Body   string
+}
+
+// Name 是报告中的端点名
+func (r Request) Name() string {
+	return r.Method + " " + r.Path
+}
+
+// DefaultMix 读多写少，覆盖 gin-sample 的商品详情、列表和评论接口
+const DefaultMix = `8 GET /api/product/1; 3 GET /api/product?limit=10; 3 GET /api/product/1/reviews; ` +
+	`1 POST /api/product/1/reviews {"author":"loadgen","rating":5}`
+
+// ParseMix 解析以分号分隔的 "权重 方法 路径 [请求体]"
+func ParseMix(spec string) ([]Request, error) {
+	var mix []Request
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, " ", 4)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("invalid mix entry %q, want \"weight METHOD PATH [BODY]\"", entry)
+		}
+		weight, err := strconv.Atoi(parts[0])
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid weight in %q", entry)
+		}
+		r := Request{Weight: weight, Method: strings.ToUpper(parts[1]), Path: parts[2]}
+		if len(parts) == 4 {
+			r.Body = parts[3]
+		}
+		mix = append(mix, r)
+	}
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("empty request mix")
+	}
+	return mix, nil
+}
+
+// Config 是一次压测的参数
+type Config struct {
+	Target      string
+	RPS         float64
+	Concurrency int
+	Duration    time.Duration
+	Mix         []Request
+	Slowest     int
+}
+
+// Sample 是一次请求的结果
+type Sample struct {
+	Request string `json:"request"`
+	httpclient.Result
+}
+
+// Endpoint 是一个端点的延迟统计，Slowest 是最慢的几次请求，用 TraceID 到 jaeger 中查看
+type Endpoint struct {
+	Name    string        `json:"name"`
+	Count   int           `json:"count"`
+	Errors  int           `json:"errors"`
+	P50     time.Duration `json:"p50"`
+	P90     time.Duration `json:"p90"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+	Slowest []Sample      `json:"slowest"`
+}
+
+// Report 是压测结果。Dropped 是所有 worker 都忙时没能按计划发出的请求数
+type Report struct {
+	Sent      int           `json:"sent"`
+	Dropped   int           `json:"dropped"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Endpoints []Endpoint    `json:"endpoints"`
+}
+
+// Run 按固定速率把请求交给 Concurrency 个 worker，持续 Duration 或直到 ctx 结束
+func Run(ctx context.Context, cfg Config, client *http.Client, tracer opentracing.Tracer) *Report {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	total := 0
+	for _, r := range cfg.Mix {
+		total += r.Weight
+	}
+	pick := func() Request {
+		n := rand.Intn(total)
+		for _, r := range cfg.Mix {
+			if n < r.Weight {
+				return r
+			}
+			n -= r.Weight
+		}
+		return cfg.Mix[len(cfg.Mix)-1]
+	}
+
+	queue := make(chan Request)
+	results := make(chan Sample, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range queue {
+				results <- do(client, tracer, cfg.Target, r)
+			}
+		}()
+	}
+	var samples []Sample
+	collected := make(chan struct{})
+	go func() {
+		for s := range results {
+			samples = append(samples, s)
+		}
+		close(collected)
+	}()
+
+	start := time.Now()
+	report := &Report{}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.RPS))
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			select {
+			case queue <- pick():
+				report.Sent++
+			default:
+				report.Dropped++
+			}
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(results)
+	<-collected
+
+	report.Elapsed = time.Since(start)
+	report.Endpoints = summarize(samples, cfg.Slowest)
+	return report
+}
+
+// do 在 loadgen 自己的根 span 下发出请求，请求本身不受压测结束的影响，已经发出的请求等它完成
+func do(client *http.Client, tracer opentracing.Tracer, target string, r Request) Sample {
+	var body io.Reader
+	if r.Body != "" {
+		body = strings.NewReader(r.Body)
+	}
+	s := Sample{Request: r.Name()}
+	req, err := http.NewRequest(r.Method, target+r.Path, body)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	if r.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.Result = httpclient.Send(client, tracer, "loadgen "+r.Name(), req, nil)
+	return s
+}
+
+// summarize 按端点统计延迟，端点按名字排序
+func summarize(samples []Sample, slowest int) []Endpoint {
+	byName := map[string][]Sample{}
+	for _, s := range samples {
+		byName[s.Request] = append(byName[s.Request], s)
+	}
+	endpoints := make([]Endpoint, 0, len(byName))
+	for name, ss := range byName {
+		sort.Slice(ss, func(i, j int) bool { return ss[i].Latency > ss[j].Latency })
+		latencies := make([]time.Duration, len(ss))
+		e := Endpoint{Name: name, Count: len(ss), Max: ss[0].Latency}
+		for i, s := range ss {
+			latencies[i] = s.Latency
+			if s.Failed() {
+				e.Errors++
+			}
+		}
+		latencies = spanstore.SortDurations(latencies)
+		e.P50 = spanstore.Percentile(latencies, 0.5)
+		e.P90 = spanstore.Percentile(latencies, 0.9)
+		e.P99 = spanstore.Percentile(latencies, 0.99)
+		n := slowest
+		if n > len(ss) {
+			n = len(ss)
+		}
+		e.Slowest = append([]Sample{}, ss[:n]...)
+		endpoints = append(endpoints, e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	return endpoints
+}
+
+// Write 以表格输出报告，每个端点下列出最慢请求的 trace ID
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "sent %d requests in %s (%.1f rps), dropped %d\n\n",
+		r.Sent, r.Elapsed.Round(time.Millisecond), float64(r.Sent)/r.Elapsed.Seconds(), r.Dropped)
+	tw := spanstore.NewTable(w)
+	fmt.Fprintln(tw, "endpoint\tcount\terrors\tp50\tp90\tp99\tmax\t")
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", e.Name, e.Count, e.Errors, spanstore.Millis(e.P50), spanstore.Millis(e.P90), spanstore.Millis(e.P99), spanstore.Millis(e.Max))
+	}
+	tw.Flush()
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(w, "\nslowest %s:\n", e.Name)
+		for _, s := range e.Slowest {
+			fmt.Fprintf(w, "  %s  trace_id=%s  x-request-id=%s  %s\n", spanstore.Millis(s.Latency), s.TraceID, s.RequestID, s.Outcome())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"net/http"
+	"net/http/httptest"
+	"opentracing-sample/httpclient"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := ParseMix(DefaultMix)
+	if err != nil || len(mix) != 4 {
+		t.Fatalf("unexpected mix %+v, %v", mix, err)
+	}
+	if last := mix[3]; last.Method != "POST" || last.Body != `{"author":"loadgen","rating":5}` || last.Weight != 1 {
+		t.Fatalf("unexpected entry %+v", last)
+	}
+	for _, spec := range []string{"", "GET /", "x GET /", "0 GET /"} {
+		if _, err := ParseMix(spec); err == nil {
+			t.Errorf("%q should be rejected", spec)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	traceHeaders := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceHeaders[r.Header.Get("x-request-id")] = r.Header.Get("uber-trace-id")
+		mu.Unlock()
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}))
+	defer srv.Close()
+
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("loadgen", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	mix, _ := ParseMix(`3 GET /api/product/1; 1 POST /api/product/1/reviews {}`)
+	cfg := Config{Target: srv.URL, RPS: 400, Concurrency: 4, Duration: 200 * time.Millisecond, Mix: mix, Slowest: 2}
+	report := Run(context.Background(), cfg, httpclient.NewClient(httpclient.WithTracer(tracer)), tracer)
+
+	if report.Sent == 0 || len(report.Endpoints) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	get, post := report.Endpoints[0], report.Endpoints[1]
+	if get.Name != "GET /api/product/1" || get.Errors != 0 || get.P50 < time.Millisecond || len(get.Slowest) != 2 {
+		t.Errorf("unexpected GET stats %+v", get)
+	}
+	if post.Errors != post.Count || get.Count+post.Count != report.Sent {
+		t.Errorf("unexpected POST stats %+v", post)
+	}
+	slow := get.Slowest[0]
+	// uber-trace-id 中的 trace ID 补足了 16 位，解析后再比较
+	sc, err := jaeger.ContextFromString(traceHeaders[slow.RequestID])
+	if slow.Latency != get.Max || err != nil || sc.TraceID().String() != slow.TraceID {
+		t.Errorf("slowest request %+v not correlated with its trace header %q", slow, traceHeaders[slow.RequestID])
+	}
+
+	// 只有 httpclient 的 span 是客户端 span，根 span 不是
+	for _, s := range reporter.GetSpans() {
+		span := s.(*jaeger.Span)
+		if kind, root := span.Tags()["span.kind"], span.SpanContext().ParentID() == 0; root == (kind == ext.SpanKindRPCClientEnum) {
+			t.Fatalf("span %s (root=%v) has span.kind %v", span.OperationName(), root, kind)
+		}
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	if !strings.Contains(out.String(), "trace_id="+slow.TraceID) {
+		t.Fatalf("report does not list the slowest trace:\n%s", out.String())
+	}
+}
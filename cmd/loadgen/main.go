@@ -0,0 +1,71 @@
+// loadgen 按设定的速率、并发和请求组合压测 gin-sample，每个请求都有自己的根 span 并注入 x-request-id，
+// 结束后按端点输出延迟分位数，并列出最慢请求的 trace ID 以便在 jaeger 中查看。
+//
+//	loadgen -target http://localhost:8080 -rps 50 -concurrency 10 -duration 30s \
+//	    -mix '8 GET /api/product/1; 1 POST /api/product/1/reviews {"author":"a","rating":5}'
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+	"log"
+	"opentracing-sample/config"
+	"opentracing-sample/httpclient"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "base URL of gin-sample")
+	rps := flag.Float64("rps", 10, "requests per second")
+	concurrency := flag.Int("concurrency", 4, "maximum requests in flight")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run")
+	mix := flag.String("mix", DefaultMix, `request mix, "weight METHOD PATH [BODY]" separated by ";"`)
+	slowest := flag.Int("slowest", 3, "number of slowest requests to list per endpoint")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	requests, err := ParseMix(*mix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *rps <= 0 || *concurrency <= 0 {
+		log.Fatal("rps and concurrency must be positive")
+	}
+
+	// 不在标准输出逐条打印 span，以免淹没报告
+	tracer, closer := config.TraceInit("loadgen", jaegercfg.Logger(jaeger.NullLogger))
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+
+	ctx, stop := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		stop()
+	}()
+
+	cfg := Config{
+		Target:      strings.TrimSuffix(*target, "/"),
+		RPS:         *rps,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Mix:         requests,
+		Slowest:     *slowest,
+	}
+	report := Run(ctx, cfg, httpclient.NewClient(httpclient.WithTracer(tracer)), tracer)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.Write(os.Stdout)
+}
@@ -28,7 +28,7 @@ func main() {
 		config.Logger(jaeger.StdLogger),
 	)
 	if err != nil {
-		fmt.Println("Cannot create tracer: %v\n", err.Error())
+		fmt.Printf("Cannot create tracer: %v\n", err.Error())
 		os.Exit(1)
 	}
 	defer closer.Close()
@@ -0,0 +1,79 @@
+// replay 从 span 导出（SPAN_FILE 写出的文件或 /debug/spans）还原根 HTTP span 的请求顺序和时间间隔，
+// 对目标 gin-sample 重新发出这些请求，并对比原来和重放时各端点的延迟。
+// span 中没有请求体，修改数据的请求默认跳过，-writes 时以空请求体发出。
+//
+//	replay -target http://localhost:8080 -speed 2 spans.json
+//	replay -target http://staging:8080 -service gin-sample http://localhost:8080/debug/spans
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	jaegercfg "github.com/uber/jaeger-client-go/config"
+	"log"
+	"opentracing-sample/config"
+	"opentracing-sample/httpclient"
+	"opentracing-sample/spanstore"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: replay [flags] <spans file or URL>")
+		flag.PrintDefaults()
+	}
+	target := flag.String("target", "http://localhost:8080", "base URL of gin-sample")
+	speed := flag.Float64("speed", 1, "time scale, 2 replays twice as fast, 0 sends without waiting")
+	service := flag.String("service", "", "only replay root spans of this service")
+	writes := flag.Bool("writes", false, "also replay requests that modify data, with an empty body")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *speed < 0 {
+		log.Fatal("speed must not be negative")
+	}
+
+	spans, err := spanstore.Load(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	requests := Extract(spans, *service)
+	if len(requests) == 0 {
+		log.Fatal("no root HTTP spans with http.method and http.url tags found")
+	}
+
+	// 不在标准输出逐条打印 span，以免淹没报告
+	tracer, closer := config.TraceInit("replay", jaegercfg.Logger(jaeger.NullLogger))
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+
+	ctx, stop := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		stop()
+	}()
+
+	cfg := Config{Target: strings.TrimSuffix(*target, "/"), Speed: *speed, Writes: *writes}
+	start := time.Now()
+	results, skipped := Run(ctx, cfg, requests, httpclient.NewClient(httpclient.WithTracer(tracer)), tracer)
+	report := NewReport(results, skipped, time.Since(start))
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	report.Write(os.Stdout)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"io"
+	"net/http"
+	"opentracing-sample/httpclient"
+	"opentracing-sample/spanstore"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagOriginalTrace 记录被重放请求原来的 trace ID，便于在 jaeger 中对照
+const TagOriginalTrace = "replay.original_trace_id"
+
+// Request 是从根 HTTP span 还原的请求，Offset 是相对第一个请求的开始时间
+type Request struct {
+	TraceID   string        `json:"trace_id"`
+	Operation string        `json:"operation"`
+	Method    string        `json:"method"`
+	URL       string        `json:"url"`
+	Offset    time.Duration `json:"offset"`
+	Latency   time.Duration `json:"latency"`
+}
+
+// Name 是报告中的端点名，使用路由模式而不是具体路径
+func (r Request) Name() string {
+	return r.Method + " " + r.Operation
+}
+
+// write 判断请求是否会修改数据，span 中没有请求体，这类请求只能以空请求体重放
+func (r Request) write() bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+}
+
+// Extract 取出服务端根 span：带有 http.method 和 http.url 标签，且父 span 不在 spans 中
+// （上游如 loadgen 的 span 通常不在同一份导出里）。service 非空时只取该服务的 span。结果按开始时间排序
+func Extract(spans []spanstore.Span, service string) []Request {
+	ids := make(map[string]bool, len(spans))
+	for _, s := range spans {
+		ids[s.TraceID+"/"+s.SpanID] = true
+	}
+	var roots []spanstore.Span
+	for _, s := range spans {
+		if s.Kind() != "server" || s.Tag(string(ext.HTTPMethod)) == "" || s.Tag(string(ext.HTTPUrl)) == "" {
+			continue
+		}
+		if service != "" && s.Service != service {
+			continue
+		}
+		if s.ParentID != "" && ids[s.TraceID+"/"+s.ParentID] {
+			continue
+		}
+		roots = append(roots, s)
+	}
+	sort.SliceStable(roots, func(i, j int) bool { return roots[i].Start.Before(roots[j].Start) })
+
+	requests := make([]Request, len(roots))
+	for i, s := range roots {
+		requests[i] = Request{
+			TraceID:   s.TraceID,
+			Operation: s.Operation,
+			Method:    s.Tag(string(ext.HTTPMethod)),
+			URL:       s.Tag(string(ext.HTTPUrl)),
+			Offset:    s.Start.Sub(roots[0].Start),
+			Latency:   s.Duration,
+		}
+	}
+	return requests
+}
+
+// Config 是一次重放的参数，Speed 为 2 时以两倍速重放，为 0 时不等待，依次立即发出
+type Config struct {
+	Target string
+	Speed  float64
+	Writes bool
+}
+
+// Result 是一次重放的结果
+type Result struct {
+	Request Request `json:"request"`
+	httpclient.Result
+}
+
+// Run 按原来的相对时间发出请求，每个请求在自己的 goroutine 中执行，保留原来的并发情况。
+// 没有设置 Writes 时跳过会修改数据的请求，返回的 skipped 是跳过的数量
+func Run(ctx context.Context, cfg Config, requests []Request, client *http.Client, tracer opentracing.Tracer) (results []Result, skipped int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+loop:
+	for _, r := range requests {
+		if r.write() && !cfg.Writes {
+			skipped++
+			continue
+		}
+		if cfg.Speed > 0 {
+			due := start.Add(time.Duration(float64(r.Offset) / cfg.Speed))
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-time.After(time.Until(due)):
+			}
+		} else if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(r Request) {
+			defer wg.Done()
+			res := do(client, tracer, cfg.Target, r)
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}(r)
+	}
+	wg.Wait()
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Request.Offset < results[j].Request.Offset })
+	return results, skipped
+}
+
+// do 在 replay 自己的根 span 下发出请求，根 span 上记录原来的 trace ID
+func do(client *http.Client, tracer opentracing.Tracer, target string, r Request) Result {
+	res := Result{Request: r}
+	req, err := http.NewRequest(r.Method, target+r.URL, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Result = httpclient.Send(client, tracer, "replay "+r.Name(), req, opentracing.Tags{TagOriginalTrace: r.TraceID})
+	return res
+}
+
+// Endpoint 对比一个端点原来和重放时的延迟
+type Endpoint struct {
+	Name        string        `json:"name"`
+	Count       int           `json:"count"`
+	Errors      int           `json:"errors"`
+	OriginalP50 time.Duration `json:"original_p50"`
+	OriginalP99 time.Duration `json:"original_p99"`
+	ReplayP50   time.Duration `json:"replay_p50"`
+	ReplayP99   time.Duration `json:"replay_p99"`
+}
+
+// Report 是重放结果，Results 按原来的顺序排列
+type Report struct {
+	Sent      int           `json:"sent"`
+	Skipped   int           `json:"skipped"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Endpoints []Endpoint    `json:"endpoints"`
+	Results   []Result      `json:"results"`
+}
+
+// NewReport 按端点汇总重放结果，端点按名字排序
+func NewReport(results []Result, skipped int, elapsed time.Duration) *Report {
+	byName := map[string][]Result{}
+	for _, r := range results {
+		byName[r.Request.Name()] = append(byName[r.Request.Name()], r)
+	}
+	report := &Report{Sent: len(results), Skipped: skipped, Elapsed: elapsed, Endpoints: []Endpoint{}, Results: results}
+	for name, rs := range byName {
+		e := Endpoint{Name: name, Count: len(rs)}
+		var original, replayed []time.Duration
+		for _, r := range rs {
+			original = append(original, r.Request.Latency)
+			replayed = append(replayed, r.Latency)
+			if r.Failed() {
+				e.Errors++
+			}
+		}
+		original, replayed = spanstore.SortDurations(original), spanstore.SortDurations(replayed)
+		e.OriginalP50, e.OriginalP99 = spanstore.Percentile(original, 0.5), spanstore.Percentile(original, 0.99)
+		e.ReplayP50, e.ReplayP99 = spanstore.Percentile(replayed, 0.5), spanstore.Percentile(replayed, 0.99)
+		report.Endpoints = append(report.Endpoints, e)
+	}
+	sort.Slice(report.Endpoints, func(i, j int) bool { return report.Endpoints[i].Name < report.Endpoints[j].Name })
+	return report
+}
+
+// Write 以表格输出每个端点原来和重放时的延迟，然后列出失败的请求
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "replayed %d requests in %s, skipped %d\n\n", r.Sent, r.Elapsed.Round(time.Millisecond), r.Skipped)
+	tw := spanstore.NewTable(w)
+	fmt.Fprintln(tw, "endpoint\tcount\terrors\toriginal p50\treplay p50\toriginal p99\treplay p99\t")
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", e.Name, e.Count, e.Errors,
+			spanstore.Millis(e.OriginalP50), spanstore.Millis(e.ReplayP50), spanstore.Millis(e.OriginalP99), spanstore.Millis(e.ReplayP99))
+	}
+	tw.Flush()
+	header := false
+	for _, res := range r.Results {
+		if !res.Failed() {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "\nfailed:")
+			header = true
+		}
+		fmt.Fprintf(w, "  %s %s  %s  trace_id=%s  original_trace_id=%s\n",
+			res.Request.Method, res.Request.URL, res.Outcome(), res.TraceID, res.Request.TraceID)
+	}
+}
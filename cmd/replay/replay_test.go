@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/uber/jaeger-client-go"
+	"net/http"
+	"net/http/httptest"
+	"opentracing-sample/httpclient"
+	"opentracing-sample/spanstore"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func root(trace, op, method, url string, start time.Time, d time.Duration) spanstore.Span {
+	return spanstore.Span{
+		TraceID: trace, SpanID: trace + "1", ParentID: "loadgen", Service: "gin-sample", Operation: op,
+		Start: start, Duration: d,
+		Tags: map[string]interface{}{"span.kind": "server", "http.method": method, "http.url": url},
+	}
+}
+
+func dump(t0 time.Time) []spanstore.Span {
+	return []spanstore.Span{
+		root("b", "/api/product/:id", "GET", "/api/product/2", t0.Add(60*time.Millisecond), 8*time.Millisecond),
+		root("a", "/api/product/:id", "GET", "/api/product/1", t0, 5*time.Millisecond),
+		root("c", "/api/product/:id/reviews", "POST", "/api/product/1/reviews", t0.Add(30*time.Millisecond), time.Millisecond),
+		// 子 span 和其他服务的 span 不重放
+		{TraceID: "a", SpanID: "a2", ParentID: "a1", Service: "gin-sample", Operation: "/api/product/:id", Start: t0,
+			Tags: map[string]interface{}{"span.kind": "server", "http.method": "GET", "http.url": "/nested"}},
+		{TraceID: "d", SpanID: "d1", Service: "auth-api", Operation: "/check", Start: t0,
+			Tags: map[string]interface{}{"span.kind": "server", "http.method": "GET", "http.url": "/check"}},
+	}
+}
+
+func TestExtract(t *testing.T) {
+	requests := Extract(dump(time.Now()), "gin-sample")
+	if len(requests) != 3 {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	a, c, b := requests[0], requests[1], requests[2]
+	if a.URL != "/api/product/1" || a.Offset != 0 || a.Latency != 5*time.Millisecond {
+		t.Errorf("unexpected first request %+v", a)
+	}
+	if c.Method != "POST" || c.Offset != 30*time.Millisecond || b.Offset != 60*time.Millisecond {
+		t.Errorf("unexpected order or offsets %+v %+v", c, b)
+	}
+	if len(Extract(dump(time.Now()), "")) != 4 {
+		t.Error("without a service filter the auth-api root should be included")
+	}
+}
+
+func TestRun(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var arrivals []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.RequestURI())
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		if r.Header.Get("uber-trace-id") == "" || r.Header.Get("x-request-id") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("replay", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	client := httpclient.NewClient(httpclient.WithTracer(tracer))
+	requests := Extract(dump(time.Now()), "gin-sample")
+
+	// 两倍速：原来相隔 60ms 的请求应相隔约 30ms 发出，POST 默认跳过
+	results, skipped := Run(context.Background(), Config{Target: srv.URL, Speed: 2}, requests, client, tracer)
+	if skipped != 1 || len(results) != 2 {
+		t.Fatalf("unexpected results %+v, skipped %d", results, skipped)
+	}
+	if paths[0] != "GET /api/product/1" || paths[1] != "GET /api/product/2" {
+		t.Fatalf("unexpected requests %v", paths)
+	}
+	if gap := arrivals[1].Sub(arrivals[0]); gap < 25*time.Millisecond || gap > 55*time.Millisecond {
+		t.Errorf("requests not scaled in time, gap %s", gap)
+	}
+	for _, res := range results {
+		if res.Failed() || res.TraceID == "" || res.TraceID == res.Request.TraceID {
+			t.Errorf("unexpected result %+v", res)
+		}
+	}
+	var tagged int
+	for _, s := range reporter.GetSpans() {
+		if s.(*jaeger.Span).Tags()[TagOriginalTrace] != nil {
+			tagged++
+		}
+	}
+	if tagged != 2 {
+		t.Errorf("expected 2 replay spans tagged with the original trace, got %d", tagged)
+	}
+
+	results, skipped = Run(context.Background(), Config{Target: srv.URL, Writes: true}, requests, client, tracer)
+	if skipped != 0 || len(results) != 3 || results[1].Request.Method != "POST" {
+		t.Fatalf("writes not replayed: %+v", results)
+	}
+
+	report := NewReport(results, skipped, time.Second)
+	if len(report.Endpoints) != 2 || report.Endpoints[0].Name != "GET /api/product/:id" || report.Endpoints[0].Count != 2 ||
+		report.Endpoints[0].OriginalP99 != 8*time.Millisecond {
+		t.Fatalf("unexpected report %+v", report.Endpoints)
+	}
+	var buf bytes.Buffer
+	report.Write(&buf)
+	if !strings.Contains(buf.String(), "POST /api/product/:id/reviews") || strings.Contains(buf.String(), "failed:") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/uber/jaeger-client-go"
 	"github.com/uber/jaeger-client-go/config"
 	"io"
+	"opentracing-sample/redact"
 	"time"
 )
 
@@ -22,7 +23,13 @@ func initJaeger(service string) (opentracing.Tracer, io.Closer) {
 			LocalAgentHostPort: "122.51.128.9:6831",
 		},
 	}
-	tracer, closer, err := cfg.New(service, config.Logger(jaeger.StdLogger))
+	reporter, err := cfg.Reporter.NewReporter(service, jaeger.NewNullMetrics(), jaeger.StdLogger)
+	if err != nil {
+		panic(fmt.Sprintf("ERROR: cannot init Jaeger reporter: %v\n", err))
+	}
+	// request/reply 会原样写进标签，上报前先经过遮盖
+	tracer, closer, err := cfg.New(service, config.Logger(jaeger.StdLogger),
+		config.Reporter(redact.NewReporter(reporter, redact.Default)))
 	if err != nil {
 		panic(fmt.Sprintf("ERROR: cannot init Jaeger: %v\n", err))
 	}
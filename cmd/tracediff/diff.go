@@ -0,0 +1,282 @@
+package main
+
+import (
+	"opentracing-sample/spanstore"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pathSeparator 连接从根到 span 的各级 service:operation
+const pathSeparator = " > "
+
+// Stats 是一个操作的耗时分布
+type Stats struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+}
+
+func newStats(ds []time.Duration) Stats {
+	ds = spanstore.SortDurations(ds)
+	return Stats{
+		Count: len(ds),
+		P50:   spanstore.Percentile(ds, 0.5),
+		P90:   spanstore.Percentile(ds, 0.9),
+		P99:   spanstore.Percentile(ds, 0.99),
+	}
+}
+
+// Latency 是一个操作前后的耗时对比，Delta 为正表示变慢
+type Latency struct {
+	Service   string        `json:"service"`
+	Operation string        `json:"operation"`
+	Before    Stats         `json:"before"`
+	After     Stats         `json:"after"`
+	P50Delta  time.Duration `json:"p50_delta"`
+	P99Delta  time.Duration `json:"p99_delta"`
+}
+
+// Rename 是同一父节点下唯一消失和唯一出现的 span，视为操作改名
+type Rename struct {
+	Parent string `json:"parent"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Diff 是两组 trace 的比较结果，Added 和 Missing 是从根开始的 span 路径
+type Diff struct {
+	BeforeTraces int       `json:"before_traces"`
+	AfterTraces  int       `json:"after_traces"`
+	Added        []string  `json:"added"`
+	Missing      []string  `json:"missing"`
+	Renamed      []Rename  `json:"renamed"`
+	Latency      []Latency `json:"latency"`
+}
+
+// filterRoot 只保留根 span 的操作名为 root 的 trace，root 为空时不过滤
+func filterRoot(spans []spanstore.Span, root string) []spanstore.Span {
+	if root == "" {
+		return spans
+	}
+	keep := map[string]bool{}
+	for _, s := range spans {
+		if s.ParentID == "" && s.Operation == root {
+			keep[s.TraceID] = true
+		}
+	}
+	var filtered []spanstore.Span
+	for _, s := range spans {
+		if keep[s.TraceID] {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
+
+// paths 返回所有 span 从根开始的路径，以及 trace 的数量
+func paths(spans []spanstore.Span) (map[string]bool, int) {
+	byID := map[string]spanstore.Span{}
+	traces := map[string]bool{}
+	for _, s := range spans {
+		byID[s.TraceID+"/"+s.SpanID] = s
+		traces[s.TraceID] = true
+	}
+	result := map[string]bool{}
+	for _, s := range spans {
+		var parts []string
+		for cur, ok := s, true; ok && len(parts) <= len(spans); cur, ok = byID[cur.TraceID+"/"+cur.ParentID] {
+			parts = append(parts, cur.Service+":"+cur.Operation)
+		}
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+		result[strings.Join(parts, pathSeparator)] = true
+	}
+	return result, len(traces)
+}
+
+func depth(path string) int {
+	return strings.Count(path, pathSeparator)
+}
+
+func split(path string) (parent, name string) {
+	i := strings.LastIndex(path, pathSeparator)
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+len(pathSeparator):]
+}
+
+// Compare 比较部署前后的两组 span。改名的 span 的子孙路径随之变化，不再重复报告为新增或缺失
+func Compare(before, after []spanstore.Span) *Diff {
+	beforePaths, beforeTraces := paths(before)
+	afterPaths, afterTraces := paths(after)
+	d := &Diff{BeforeTraces: beforeTraces, AfterTraces: afterTraces, Added: []string{}, Missing: []string{}, Renamed: []Rename{}}
+
+	added, missing := map[string]bool{}, map[string]bool{}
+	maxDepth := 0
+	for p := range afterPaths {
+		if !beforePaths[p] {
+			added[p] = true
+		}
+	}
+	for p := range beforePaths {
+		if !afterPaths[p] {
+			missing[p] = true
+			if depth(p) > maxDepth {
+				maxDepth = depth(p)
+			}
+		}
+	}
+
+	// 按深度逐层处理，上层的改名先确定，下层路径据此换成新前缀再比较
+	renamed := map[string]string{}
+	rewrite := func(p string) string {
+		for parent, _ := split(p); parent != ""; parent, _ = split(parent) {
+			if to, ok := renamed[parent]; ok {
+				return to + p[len(parent):]
+			}
+		}
+		return p
+	}
+	for level := 0; level <= maxDepth; level++ {
+		gone, appeared := map[string][]string{}, map[string][]string{}
+		for m := range missing {
+			if depth(m) != level {
+				continue
+			}
+			if r := rewrite(m); r != m && added[r] {
+				delete(missing, m)
+				delete(added, r)
+				continue
+			}
+			parent, _ := split(rewrite(m))
+			gone[parent] = append(gone[parent], m)
+		}
+		for a := range added {
+			if depth(a) == level {
+				parent, _ := split(a)
+				appeared[parent] = append(appeared[parent], a)
+			}
+		}
+		for parent, from := range gone {
+			for m, a := range match(from, appeared[parent], beforePaths, afterPaths) {
+				_, fromName := split(m)
+				_, toName := split(a)
+				d.Renamed = append(d.Renamed, Rename{Parent: parent, From: fromName, To: toName})
+				renamed[m] = a
+				delete(missing, m)
+				delete(added, a)
+			}
+		}
+	}
+	for p := range added {
+		d.Added = append(d.Added, p)
+	}
+	for p := range missing {
+		d.Missing = append(d.Missing, p)
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Missing)
+	sort.Slice(d.Renamed, func(i, j int) bool {
+		return d.Renamed[i].Parent+d.Renamed[i].From < d.Renamed[j].Parent+d.Renamed[j].From
+	})
+
+	d.Latency = compareLatency(before, after)
+	return d
+}
+
+// children 返回 p 的直接子节点名字，排序后拼成一个字符串便于比较
+func children(all map[string]bool, p string) string {
+	var names []string
+	for c := range all {
+		if parent, name := split(c); parent == p {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// match 在同一父节点下配对消失和出现的 span：子节点相同且唯一的先配对，
+// 剩下的各只有一个时也视为改名
+func match(gone, appeared []string, before, after map[string]bool) map[string]string {
+	sort.Strings(gone)
+	sort.Strings(appeared)
+	pairs := map[string]string{}
+	used := map[string]bool{}
+	for _, m := range gone {
+		want := children(before, m)
+		if want == "" {
+			continue
+		}
+		var found []string
+		for _, a := range appeared {
+			if !used[a] && children(after, a) == want {
+				found = append(found, a)
+			}
+		}
+		if len(found) == 1 {
+			pairs[m] = found[0]
+			used[found[0]] = true
+		}
+	}
+	var restGone, restAppeared []string
+	for _, m := range gone {
+		if _, ok := pairs[m]; !ok {
+			restGone = append(restGone, m)
+		}
+	}
+	for _, a := range appeared {
+		if !used[a] {
+			restAppeared = append(restAppeared, a)
+		}
+	}
+	if len(restGone) == 1 && len(restAppeared) == 1 {
+		pairs[restGone[0]] = restAppeared[0]
+	}
+	return pairs
+}
+
+type operation struct {
+	service, name string
+}
+
+func durations(spans []spanstore.Span) map[operation][]time.Duration {
+	result := map[operation][]time.Duration{}
+	for _, s := range spans {
+		op := operation{service: s.Service, name: s.Operation}
+		result[op] = append(result[op], s.Duration)
+	}
+	return result
+}
+
+// compareLatency 按操作比较耗时分位数，变慢最多的排在前面
+func compareLatency(before, after []spanstore.Span) []Latency {
+	b, a := durations(before), durations(after)
+	ops := map[operation]bool{}
+	for op := range b {
+		ops[op] = true
+	}
+	for op := range a {
+		ops[op] = true
+	}
+	result := make([]Latency, 0, len(ops))
+	for op := range ops {
+		l := Latency{Service: op.service, Operation: op.name, Before: newStats(b[op]), After: newStats(a[op])}
+		if l.Before.Count > 0 && l.After.Count > 0 {
+			l.P50Delta = l.After.P50 - l.Before.P50
+			l.P99Delta = l.After.P99 - l.Before.P99
+		}
+		result = append(result, l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].P99Delta != result[j].P99Delta {
+			return result[i].P99Delta > result[j].P99Delta
+		}
+		return result[i].Service+result[i].Operation < result[j].Service+result[j].Operation
+	})
+	return result
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"opentracing-sample/spanstore"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func trace(id string, checkToken time.Duration, children ...string) []spanstore.Span {
+	spans := []spanstore.Span{
+		{TraceID: id, SpanID: "1", Service: "gin-sample-tracing", Operation: "/api/product/:id", Duration: 100 * time.Millisecond},
+		{TraceID: id, SpanID: "2", ParentID: "1", Service: "gin-sample-tracing", Operation: "checkToken", Duration: checkToken},
+		{TraceID: id, SpanID: "3", ParentID: "2", Service: "auth-api-grpc", Operation: "/Greeter/SayHello", Duration: checkToken / 2},
+	}
+	for i, op := range children {
+		spans = append(spans, spanstore.Span{TraceID: id, SpanID: string(rune('a' + i)), ParentID: "1",
+			Service: "gin-sample-tracing", Operation: op, Duration: 10 * time.Millisecond})
+	}
+	return spans
+}
+
+func TestCompare(t *testing.T) {
+	before := append(trace("b1", 10*time.Millisecond, "doSomething1", "doSomething2"),
+		trace("b2", 20*time.Millisecond, "doSomething1", "doSomething2")...)
+	after := append(trace("a1", 40*time.Millisecond, "getProduct", "doSomething2", "listReviews", "cache"),
+		trace("a2", 50*time.Millisecond, "getProduct", "doSomething2", "listReviews", "cache")...)
+	// checkToken 改名后，它下面的 grpc 调用路径也随之变化，不应报告为新增或缺失
+	for i := range after {
+		if after[i].Operation == "checkToken" {
+			after[i].Operation = "tokenRequired"
+		}
+	}
+
+	d := Compare(before, after)
+	if d.BeforeTraces != 2 || d.AfterTraces != 2 {
+		t.Fatalf("unexpected trace counts %+v", d)
+	}
+	if len(d.Renamed) != 1 || d.Renamed[0].From != "gin-sample-tracing:checkToken" || d.Renamed[0].To != "gin-sample-tracing:tokenRequired" {
+		t.Fatalf("unexpected renames %+v", d.Renamed)
+	}
+	want := []string{
+		"gin-sample-tracing:/api/product/:id > gin-sample-tracing:cache",
+		"gin-sample-tracing:/api/product/:id > gin-sample-tracing:getProduct",
+		"gin-sample-tracing:/api/product/:id > gin-sample-tracing:listReviews",
+	}
+	if strings.Join(d.Added, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected added %v", d.Added)
+	}
+	if len(d.Missing) != 1 || d.Missing[0] != "gin-sample-tracing:/api/product/:id > gin-sample-tracing:doSomething1" {
+		t.Fatalf("unexpected missing %v", d.Missing)
+	}
+
+	grpc := d.Latency[0]
+	if grpc.Operation != "/Greeter/SayHello" || grpc.Before.P50 != 5*time.Millisecond || grpc.After.P99 != 25*time.Millisecond ||
+		grpc.P99Delta != 15*time.Millisecond {
+		t.Fatalf("largest regression should come first: %+v", d.Latency)
+	}
+
+	var out bytes.Buffer
+	report(&out, d)
+	if !strings.Contains(out.String(), "renamed: gin-sample-tracing:checkToken -> gin-sample-tracing:tokenRequired") ||
+		!strings.Contains(out.String(), "+15.0ms") {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestFilterRoot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	f, err := spanstore.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range append(trace("t1", time.Millisecond), spanstore.Span{TraceID: "t2", SpanID: "1", Operation: "/api/product"}) {
+		f.Consume(s)
+	}
+	f.Close()
+
+	spans, err := spanstore.Load(path)
+	if err != nil || len(spans) != 4 {
+		t.Fatalf("unexpected spans %+v, %v", spans, err)
+	}
+	if got := filterRoot(spans, "/api/product/:id"); len(got) != 3 || got[0].TraceID != "t1" {
+		t.Fatalf("unexpected filter result %+v", got)
+	}
+}
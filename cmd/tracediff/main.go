@@ -0,0 +1,80 @@
+// tracediff 比较部署前后的两组 trace，报告结构差异（新增、缺失、改名的 span）和每个操作的耗时分位数变化。
+// 输入可以是 spanstore.File 写出的 JSON 行文件、/debug/spans 输出的 JSON 数组文件，
+// 也可以直接是 gin-sample 的 /debug/spans 地址。
+//
+//	tracediff [-root /api/product/:id] [-json] before.jsonl after.jsonl
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"opentracing-sample/spanstore"
+	"os"
+	"time"
+)
+
+func main() {
+	root := flag.String("root", "", "only compare traces whose root span has this operation name")
+	asJSON := flag.Bool("json", false, "print the diff as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <before> <after>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	before, err := spanstore.Load(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("could not read %s: %v", flag.Arg(0), err)
+	}
+	after, err := spanstore.Load(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("could not read %s: %v", flag.Arg(1), err)
+	}
+	d := Compare(filterRoot(before, *root), filterRoot(after, *root))
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+		return
+	}
+	report(os.Stdout, d)
+}
+
+func report(w io.Writer, d *Diff) {
+	fmt.Fprintf(w, "traces: %d before, %d after\n", d.BeforeTraces, d.AfterTraces)
+	for _, r := range d.Renamed {
+		fmt.Fprintf(w, "renamed: %s -> %s (under %s)\n", r.From, r.To, r.Parent)
+	}
+	for _, p := range d.Added {
+		fmt.Fprintf(w, "added:   %s\n", p)
+	}
+	for _, p := range d.Missing {
+		fmt.Fprintf(w, "missing: %s\n", p)
+	}
+
+	fmt.Fprintln(w)
+	tw := spanstore.NewTable(w)
+	fmt.Fprintln(tw, "service\toperation\tbefore n\tp50\tp99\tafter n\tp50\tp99\tΔp50\tΔp99\t")
+	for _, l := range d.Latency {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t\n", l.Service, l.Operation,
+			l.Before.Count, spanstore.Millis(l.Before.P50), spanstore.Millis(l.Before.P99),
+			l.After.Count, spanstore.Millis(l.After.P50), spanstore.Millis(l.After.P99),
+			delta(l.P50Delta), delta(l.P99Delta))
+	}
+	tw.Flush()
+}
+
+func delta(d time.Duration) string {
+	if d > 0 {
+		return "+" + spanstore.Millis(d)
+	}
+	return spanstore.Millis(d)
+}
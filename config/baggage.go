@@ -0,0 +1,231 @@
+package config
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// 允许跨服务传播的 baggage key
+const (
+	BaggageTenant     = "tenant"
+	BaggageUserTier   = "user-tier"
+	BaggageExperiment = "experiment"
+	// BaggageFault 用于把注入的故障带给下游服务，只在启用了故障注入的服务中生效
+	BaggageFault = "fault"
+)
+
+// BaggagePolicy 控制哪些 baggage 可以跨服务边界，以及单项和总大小的上限（字节）
+type BaggagePolicy struct {
+	Allowed      []string
+	MaxItemSize  int
+	MaxTotalSize int
+}
+
+// Baggage 是全局的 baggage 策略，服务启动时可以按需修改
+var Baggage = &BaggagePolicy{
+	Allowed:      []string{BaggageTenant, BaggageUserTier, BaggageExperiment, BaggageFault},
+	MaxItemSize:  128,
+	MaxTotalSize: 512,
+}
+
+// BaggagePrefixes 是各传播格式在载体中表示 baggage 的 key 前缀：jaeger 为 uberctx-，B3 为 baggage-
+var BaggagePrefixes = []string{"uberctx-", "baggage-"}
+
+// jaeger-baggage 头可以一次带任意多项，绕过白名单，因此总是丢弃
+const jaegerBaggageHeader = "jaeger-baggage"
+
+func (p *BaggagePolicy) allowed(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range p.Allowed {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate 按单项上限截断 value，并在截断时记录日志
+func (p *BaggagePolicy) truncate(key, value string) string {
+	if p.MaxItemSize > 0 && len(value) > p.MaxItemSize {
+		Log.WithField("component", "baggage").
+			Warnf("baggage %q truncated from %d to %d bytes", key, len(value), p.MaxItemSize)
+		return value[:p.MaxItemSize]
+	}
+	return value
+}
+
+// baggageKey 判断载体中的 key 是否是 baggage，返回去掉前缀后的 baggage key
+func baggageKey(carrierKey string) (string, bool) {
+	lower := strings.ToLower(carrierKey)
+	for _, prefix := range BaggagePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return lower[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// admit 判断载体中的一项能否通过策略，total 是已经通过的 baggage 总大小
+func (p *BaggagePolicy) admit(carrierKey, value string, total *int) (string, bool) {
+	if strings.ToLower(carrierKey) == jaegerBaggageHeader {
+		return "", false
+	}
+	key, ok := baggageKey(carrierKey)
+	if !ok {
+		return value, true
+	}
+	if !p.allowed(key) {
+		return "", false
+	}
+	value = p.truncate(key, value)
+	if p.MaxTotalSize > 0 && *total+len(key)+len(value) > p.MaxTotalSize {
+		Log.WithField("component", "baggage").
+			Warnf("baggage %q dropped, total size would exceed %d bytes", key, p.MaxTotalSize)
+		return "", false
+	}
+	*total += len(key) + len(value)
+	return value, true
+}
+
+// BaggageReader 包装提取用的载体，跳过不在白名单中或超出大小限制的 baggage
+type BaggageReader struct {
+	opentracing.TextMapReader
+}
+
+func (r BaggageReader) ForeachKey(handler func(key, val string) error) error {
+	total := 0
+	return r.TextMapReader.ForeachKey(func(key, val string) error {
+		val, ok := Baggage.admit(key, val, &total)
+		if !ok {
+			return nil
+		}
+		return handler(key, val)
+	})
+}
+
+// BaggageWriter 包装注入用的载体，只写出白名单内且符合大小限制的 baggage
+type BaggageWriter struct {
+	opentracing.TextMapWriter
+	total int
+}
+
+func (w *BaggageWriter) Set(key, val string) {
+	if val, ok := Baggage.admit(key, val, &w.total); ok {
+		w.TextMapWriter.Set(key, val)
+	}
+}
+
+// SetBaggage 在 ctx 当前的 span 上设置 baggage，不在白名单中的 key 会被拒绝，超长的值会被截断
+func SetBaggage(ctx context.Context, key, value string) bool {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil || !Baggage.allowed(key) {
+		return false
+	}
+	key = strings.ToLower(key)
+	value = Baggage.truncate(key, value)
+	span.SetBaggageItem(key, value)
+	span.SetTag("baggage."+key, value)
+	return true
+}
+
+// GetBaggage 读取 ctx 当前 span 上的 baggage
+func GetBaggage(ctx context.Context, key string) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	return span.BaggageItem(strings.ToLower(key))
+}
+
+// AllowedBaggage 返回 span 上所有白名单内的 baggage
+func AllowedBaggage(span opentracing.Span) map[string]string {
+	items := map[string]string{}
+	if span == nil {
+		return items
+	}
+	span.Context().ForeachBaggageItem(func(k, v string) bool {
+		if Baggage.allowed(k) {
+			items[k] = v
+		}
+		return true
+	})
+	return items
+}
+
+// TagBaggage 把白名单内的 baggage 作为 baggage.<key> 标签写到 span 上
+func TagBaggage(span opentracing.Span) {
+	for k, v := range AllowedBaggage(span) {
+		span.SetTag("baggage."+k, v)
+	}
+}
+
+// BaggageFields 返回可以直接传给 Log.WithFields 的 baggage 字段
+func BaggageFields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+	for k, v := range AllowedBaggage(opentracing.SpanFromContext(ctx)) {
+		fields[k] = v
+	}
+	return fields
+}
+
+// filterIncomingBaggage 按策略过滤入站 metadata 中的 baggage，需要放在 opentracing 拦截器之前
+func filterIncomingBaggage(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	// ForeachKey 把 -bin 的值编码成 base64，经 Set 写回时解码，保持原来的二进制值
+	filtered := MDReaderWriter{MD: metadata.MD{}}
+	BaggageReader{TextMapReader: MDReaderWriter{MD: md}}.ForeachKey(func(key, val string) error {
+		filtered.Set(key, val)
+		return nil
+	})
+	return metadata.NewIncomingContext(ctx, filtered.MD)
+}
+
+// BaggageUnaryServerInterceptor 过滤入站 baggage，需要放在 opentracing 拦截器之前
+func BaggageUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(filterIncomingBaggage(ctx), req)
+	}
+}
+
+// BaggageStreamServerInterceptor 是 BaggageUnaryServerInterceptor 的流式版本
+func BaggageStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, ctx: filterIncomingBaggage(ss.Context())})
+	}
+}
+
+// TagBaggageUnaryServerInterceptor 把 baggage 复制到服务端 span 的标签上，需要放在 opentracing 拦截器之后
+func TagBaggageUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if span := opentracing.SpanFromContext(ctx); span != nil {
+			TagBaggage(span)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TagBaggageStreamServerInterceptor 是 TagBaggageUnaryServerInterceptor 的流式版本
+func TagBaggageStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if span := opentracing.SpanFromContext(ss.Context()); span != nil {
+			TagBaggage(span)
+		}
+		return handler(srv, ss)
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"testing"
+)
+
+func TestBaggageReader(t *testing.T) {
+	h := opentracing.HTTPHeadersCarrier{
+		"X-B3-Traceid":      {"2f4b419adf0f50953c5aa47b98941f3e"},
+		"X-B3-Spanid":       {"3c5aa47b98941f3e"},
+		"Baggage-Tenant":    {"acme"},
+		"Baggage-Password":  {"hunter2"},
+		"Jaeger-Baggage":    {"password=hunter2"},
+		"Uberctx-User-Tier": {strings.Repeat("g", Baggage.MaxItemSize+10)},
+	}
+	sc, err := ZipkinPropagator.Extract(BaggageReader{TextMapReader: h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		got[k] = v
+		return true
+	})
+	if len(got) != 1 || got[BaggageTenant] != "acme" {
+		t.Fatalf("unexpected baggage: %v", got)
+	}
+
+	var tier string
+	BaggageReader{TextMapReader: h}.ForeachKey(func(k, v string) error {
+		if k == "Uberctx-User-Tier" {
+			tier = v
+		}
+		return nil
+	})
+	if len(tier) != Baggage.MaxItemSize {
+		t.Fatalf("item not truncated: %d bytes", len(tier))
+	}
+}
+
+func TestBaggageRoundTripThroughMetadata(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	span := tracer.StartSpan("client")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	if !SetBaggage(ctx, "Tenant", "acme") {
+		t.Fatal("tenant should be allowed")
+	}
+	if SetBaggage(ctx, "password", "hunter2") {
+		t.Fatal("password should be rejected")
+	}
+	span.SetBaggageItem("internal", "x")
+	if GetBaggage(ctx, BaggageTenant) != "acme" {
+		t.Fatal("GetBaggage did not return tenant")
+	}
+
+	md := metadata.MD{}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, &BaggageWriter{TextMapWriter: MDReaderWriter{MD: md}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := md["uberctx-internal"]; ok {
+		t.Fatalf("disallowed baggage leaked into metadata: %v", md)
+	}
+
+	sc, err := tracer.Extract(opentracing.TextMap, MDReaderWriter{MD: md})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := tracer.StartSpan("server", opentracing.ChildOf(sc))
+	if f := BaggageFields(opentracing.ContextWithSpan(context.Background(), server)); len(f) != 1 || f[BaggageTenant] != "acme" {
+		t.Fatalf("unexpected log fields: %v", f)
+	}
+}
+
+func TestBaggageTotalSize(t *testing.T) {
+	old := *Baggage
+	defer func() { *Baggage = old }()
+	Baggage.MaxTotalSize = 20
+
+	md := metadata.MD{}
+	w := &BaggageWriter{TextMapWriter: MDReaderWriter{MD: md}}
+	w.Set("uberctx-tenant", "acme")
+	w.Set("uberctx-experiment", "new-checkout-flow")
+	if len(md) != 1 {
+		t.Fatalf("expected only the first item within the total limit, got %v", md)
+	}
+}
+
+func TestBaggageUnaryServerInterceptor(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{
+		"grpc-trace-bin": {"\x00\xff\x10"},
+		"uberctx-tenant": {"acme"},
+		"uberctx-secret": {"hunter2"},
+	})
+	var got metadata.MD
+	BaggageUnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			got, _ = metadata.FromIncomingContext(ctx)
+			return nil, nil
+		})
+	// 二进制 metadata 原样保留，不能变成 base64
+	if vs := got["grpc-trace-bin"]; len(vs) != 1 || vs[0] != "\x00\xff\x10" {
+		t.Fatalf("binary metadata changed: %q", vs)
+	}
+	if got.Get("uberctx-tenant")[0] != "acme" || len(got.Get("uberctx-secret")) != 0 {
+		t.Fatalf("baggage not filtered: %v", got)
+	}
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"opentracing-sample/redact"
+	"strings"
+	"sync"
+)
+
+// 记录请求头和 metadata 的标签前缀，后接小写的名字
+const (
+	TagRequestHeader    = "http.request.header."
+	TagResponseHeader   = "http.response.header."
+	TagRequestMetadata  = "grpc.request.metadata."
+	TagResponseMetadata = "grpc.response.metadata."
+)
+
+// CapturePolicy 指定哪些 HTTP 头和 gRPC metadata 记录为 span 标签。
+// 值先按 redact.Default 遮盖，再截断到 MaxValueLength。
+type CapturePolicy struct {
+	RequestHeaders   []string
+	ResponseHeaders  []string
+	RequestMetadata  []string
+	ResponseMetadata []string
+	MaxValueLength   int
+}
+
+// Capture 是全局的采集策略，服务启动时可以按需修改
+var Capture = &CapturePolicy{
+	RequestHeaders:   []string{"User-Agent", "X-Envoy-Decorator-Operation", "X-Forwarded-For", "X-Request-Id"},
+	ResponseHeaders:  []string{"Content-Type"},
+	RequestMetadata:  []string{"user-agent", "x-envoy-decorator-operation", "x-request-id"},
+	ResponseMetadata: []string{"content-type"},
+	MaxValueLength:   256,
+}
+
+func (p *CapturePolicy) value(name string, vs []string) string {
+	v := strings.Join(redact.Default.Values(name, vs), ",")
+	if p.MaxValueLength > 0 && len(v) > p.MaxValueLength {
+		v = v[:p.MaxValueLength] + "..."
+	}
+	return v
+}
+
+func (p *CapturePolicy) tagHeaders(span opentracing.Span, prefix string, names []string, h http.Header) {
+	for _, name := range names {
+		if vs := h.Values(name); len(vs) > 0 {
+			span.SetTag(prefix+strings.ToLower(name), p.value(name, vs))
+		}
+	}
+}
+
+func (p *CapturePolicy) tagMetadata(span opentracing.Span, prefix string, keys []string, md metadata.MD) {
+	for _, key := range keys {
+		if vs := md.Get(key); len(vs) > 0 {
+			span.SetTag(prefix+strings.ToLower(key), p.value(key, vs))
+		}
+	}
+}
+
+// TagRequestHeaders 把配置的 HTTP 请求头记录到 span 上
+func (p *CapturePolicy) TagRequestHeaders(span opentracing.Span, h http.Header) {
+	p.tagHeaders(span, TagRequestHeader, p.RequestHeaders, h)
+}
+
+// TagResponseHeaders 把配置的 HTTP 响应头记录到 span 上
+func (p *CapturePolicy) TagResponseHeaders(span opentracing.Span, h http.Header) {
+	p.tagHeaders(span, TagResponseHeader, p.ResponseHeaders, h)
+}
+
+// TagRequestMetadata 把配置的 gRPC 请求 metadata 记录到 span 上
+func (p *CapturePolicy) TagRequestMetadata(span opentracing.Span, md metadata.MD) {
+	p.tagMetadata(span, TagRequestMetadata, p.RequestMetadata, md)
+}
+
+// TagResponseMetadata 把配置的 gRPC 响应 metadata 记录到 span 上
+func (p *CapturePolicy) TagResponseMetadata(span opentracing.Span, md metadata.MD) {
+	p.tagMetadata(span, TagResponseMetadata, p.ResponseMetadata, md)
+}
+
+// CaptureUnaryServerInterceptor 记录入站 metadata，以及处理函数通过 grpc.SetHeader、grpc.SendHeader 和
+// grpc.SetTrailer 返回的 metadata，需要放在 opentracing 拦截器之后。
+// grpc 传输层自己加上的 content-type 等不经过处理函数，记录不到
+func CaptureUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		captureIncoming(ctx)
+		stream := grpc.ServerTransportStreamFromContext(ctx)
+		if stream == nil {
+			return handler(ctx, req)
+		}
+		cs := &captureTransportStream{ServerTransportStream: stream}
+		resp, err := handler(grpc.NewContextWithServerTransportStream(ctx, cs), req)
+		captureOutgoing(ctx, &cs.sent)
+		return resp, err
+	}
+}
+
+// CaptureStreamServerInterceptor 是 CaptureUnaryServerInterceptor 的流式版本
+func CaptureStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		captureIncoming(ss.Context())
+		cs := &captureServerStream{ServerStream: ss}
+		err := handler(srv, cs)
+		captureOutgoing(ss.Context(), &cs.sent)
+		return err
+	}
+}
+
+func captureIncoming(ctx context.Context) {
+	span := opentracing.SpanFromContext(ctx)
+	md, ok := metadata.FromIncomingContext(ctx)
+	if span != nil && ok {
+		Capture.TagRequestMetadata(span, md)
+	}
+}
+
+func captureOutgoing(ctx context.Context, sent *sentMetadata) {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		Capture.TagResponseMetadata(span, sent.get())
+	}
+}
+
+// sentMetadata 累积处理函数设置的 header 和 trailer，两者合并记录
+type sentMetadata struct {
+	mu sync.Mutex
+	md metadata.MD
+}
+
+func (s *sentMetadata) add(md metadata.MD) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.md = metadata.Join(s.md, md)
+}
+
+func (s *sentMetadata) get() metadata.MD {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.md
+}
+
+// captureTransportStream 替换上下文中的 ServerTransportStream，grpc.SetHeader 等函数经过它发送 metadata
+type captureTransportStream struct {
+	grpc.ServerTransportStream
+	sent sentMetadata
+}
+
+func (s *captureTransportStream) SetHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SetHeader(md)
+	if err == nil {
+		s.sent.add(md)
+	}
+	return err
+}
+
+func (s *captureTransportStream) SendHeader(md metadata.MD) error {
+	err := s.ServerTransportStream.SendHeader(md)
+	if err == nil {
+		s.sent.add(md)
+	}
+	return err
+}
+
+func (s *captureTransportStream) SetTrailer(md metadata.MD) error {
+	err := s.ServerTransportStream.SetTrailer(md)
+	if err == nil {
+		s.sent.add(md)
+	}
+	return err
+}
+
+type captureServerStream struct {
+	grpc.ServerStream
+	sent sentMetadata
+}
+
+func (s *captureServerStream) SetHeader(md metadata.MD) error {
+	err := s.ServerStream.SetHeader(md)
+	if err == nil {
+		s.sent.add(md)
+	}
+	return err
+}
+
+func (s *captureServerStream) SendHeader(md metadata.MD) error {
+	err := s.ServerStream.SendHeader(md)
+	if err == nil {
+		s.sent.add(md)
+	}
+	return err
+}
+
+func (s *captureServerStream) SetTrailer(md metadata.MD) {
+	s.ServerStream.SetTrailer(md)
+	s.sent.add(md)
+}
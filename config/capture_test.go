@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCaptureHeaders(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("GET /api/product/:id").(*mocktracer.MockSpan)
+
+	Capture.TagRequestHeaders(span, http.Header{
+		"User-Agent":                  {"Mozilla/5.0 " + strings.Repeat("x", Capture.MaxValueLength)},
+		"X-Envoy-Decorator-Operation": {"gin-sample-tracing.istio-sample.svc.cluster.local:80/api/product"},
+		"X-Forwarded-For":             {"alice@example.com"},
+		"Cookie":                      {"_gitlab_session=e67f65e588be2730a3006cdae744e8a1"},
+	})
+	Capture.TagResponseHeaders(span, http.Header{"Content-Type": {"application/json"}})
+
+	tags := span.Tags()
+	if got := tags[TagRequestHeader+"x-envoy-decorator-operation"]; got != "gin-sample-tracing.istio-sample.svc.cluster.local:80/api/product" {
+		t.Fatalf("unexpected operation tag %v", got)
+	}
+	if got := tags[TagRequestHeader+"user-agent"].(string); len(got) != Capture.MaxValueLength+3 {
+		t.Fatalf("user-agent not capped: %d", len(got))
+	}
+	if got := tags[TagRequestHeader+"x-forwarded-for"]; strings.Contains(got.(string), "alice@example.com") {
+		t.Fatalf("value not redacted: %v", got)
+	}
+	if _, ok := tags[TagRequestHeader+"cookie"]; ok {
+		t.Fatal("header outside capture policy recorded")
+	}
+	if got := tags[TagResponseHeader+"content-type"]; got != "application/json" {
+		t.Fatalf("unexpected content-type tag %v", got)
+	}
+}
+
+func TestCaptureUnaryServerInterceptor(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("/helloworld.Greeter/SayHello")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"user-agent", "grpc-go/1.34.0",
+		"X-Request-Id", "ca10652c-7872-9c7a-83dc-15a735ace717",
+		"authorization", "Bearer secret",
+	))
+
+	defer func(keys []string) { Capture.ResponseMetadata = keys }(Capture.ResponseMetadata)
+	Capture.ResponseMetadata = []string{"x-cache", "x-retry-after"}
+	stream := &fakeTransportStream{}
+	ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+
+	_, err := CaptureUnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			grpc.SetHeader(ctx, metadata.Pairs("x-cache", "hit", "x-internal", "1"))
+			grpc.SetTrailer(ctx, metadata.Pairs("x-retry-after", "5"))
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	span.Finish()
+
+	tags := tracer.FinishedSpans()[0].Tags()
+	if tags[TagRequestMetadata+"user-agent"] != "grpc-go/1.34.0" ||
+		tags[TagRequestMetadata+"x-request-id"] != "ca10652c-7872-9c7a-83dc-15a735ace717" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if _, ok := tags[TagRequestMetadata+"authorization"]; ok {
+		t.Fatal("metadata outside capture policy recorded")
+	}
+	if tags[TagResponseMetadata+"x-cache"] != "hit" || tags[TagResponseMetadata+"x-retry-after"] != "5" {
+		t.Fatalf("response metadata not recorded: %v", tags)
+	}
+	if _, ok := tags[TagResponseMetadata+"x-internal"]; ok {
+		t.Fatal("metadata outside capture policy recorded")
+	}
+	if stream.header.Get("x-cache")[0] != "hit" || stream.trailer.Get("x-retry-after")[0] != "5" {
+		t.Fatalf("metadata not passed to the transport stream: %v %v", stream.header, stream.trailer)
+	}
+}
+
+type fakeTransportStream struct {
+	header, trailer metadata.MD
+}
+
+func (s *fakeTransportStream) Method() string { return "/helloworld.Greeter/SayHello" }
+
+func (s *fakeTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *fakeTransportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *fakeTransportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
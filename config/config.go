@@ -8,18 +8,21 @@ import (
 	"github.com/uber/jaeger-client-go"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
 	"github.com/uber/jaeger-client-go/zipkin"
-	"google.golang.org/grpc/metadata"
 	"io"
+	"opentracing-sample/redact"
 	"os"
-	"strings"
 )
 
 var (
-	Log              = logrus.New()
-	ZipkinPropagator zipkin.Propagator
+	Log = logrus.New()
+	// ZipkinPropagator 的 baggage 前缀必须是 baggage-，零值会把所有请求头都当成 baggage
+	ZipkinPropagator = zipkin.NewZipkinB3HTTPHeaderPropagator()
+
+	// ReporterDecorators 依次包装 TraceInit 创建的 reporter，用于在上报前消费已结束的 span
+	ReporterDecorators []func(jaeger.Reporter) jaeger.Reporter
 )
 
-func TraceInit(serviceName string) (opentracing.Tracer, io.Closer) {
+func TraceInit(serviceName string, options ...jaegercfg.Option) (opentracing.Tracer, io.Closer) {
 	cfg := &jaegercfg.Configuration{
 		Sampler: &jaegercfg.SamplerConfig{
 			Type:  "const",
@@ -39,38 +42,39 @@ func TraceInit(serviceName string) (opentracing.Tracer, io.Closer) {
 	//	jaegercfg.Injector(opentracing.HTTPHeaders, ZipkinPropagator),
 	//	jaegercfg.Extractor(opentracing.HTTPHeaders, ZipkinPropagator))
 
-	tracer, closer, err := cfg.NewTracer(jaegercfg.Logger(jaeger.StdLogger))
+	reporter, err := cfg.Reporter.NewReporter(serviceName, jaeger.NewNullMetrics(), jaeger.StdLogger)
+	if err != nil {
+		panic(fmt.Sprintf("ERROR: cannot init Jaeger reporter: %v\n", err))
+	}
+	// 遮盖放在最外层，最先执行，其他装饰器和实际上报看到的都是遮盖后的标签和日志
+	decorators := append(append([]func(jaeger.Reporter) jaeger.Reporter{}, ReporterDecorators...), redactReporter)
+	for _, decorate := range decorators {
+		reporter = decorate(reporter)
+	}
+	base := []jaegercfg.Option{jaegercfg.Logger(jaeger.StdLogger), jaegercfg.Reporter(reporter)}
+	tracer, closer, err := cfg.NewTracer(append(base, options...)...)
 	if err != nil {
 		panic(fmt.Sprintf("ERROR: cannot init Jaeger: %v\n", err))
 	}
 	return tracer, closer
 }
 
-type MDReaderWriter struct {
-	metadata.MD
+func redactReporter(r jaeger.Reporter) jaeger.Reporter {
+	return redact.NewReporter(r, redact.Default)
 }
 
-// ForeachKey 实现opentracing.TextMapReader
-func (c MDReaderWriter) ForeachKey(handler func(key, val string) error) error {
-	for k, vs := range c.MD {
-		for _, v := range vs {
-			if err := handler(k, v); err != nil {
-				return err
-			}
-		}
+// TraceID 返回 span 所属 trace 的 ID，用于日志关联；不是 jaeger 的 span 时返回空串
+func TraceID(span opentracing.Span) string {
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
 	}
-	return nil
-}
-
-// Set 实现 opentracing.TextMapWriter 接口
-func (c MDReaderWriter) Set(key, val string) {
-	key = strings.ToLower(key)
-	c.MD[key] = append(c.MD[key], val)
+	return ""
 }
 
 func init() {
 	Log.SetLevel(logrus.DebugLevel)
 	Log.SetOutput(os.Stdout)
+	Log.AddHook(redact.NewHook(redact.Default))
 	Log.SetFormatter(&nested.Formatter{
 		HideKeys:        true,
 		TimestampFormat: "2006-01-02 15:04:05",
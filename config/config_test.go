@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/uber/jaeger-client-go"
+	"testing"
+)
+
+func TestTraceInitDecoratesReporter(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	ReporterDecorators = []func(jaeger.Reporter) jaeger.Reporter{func(next jaeger.Reporter) jaeger.Reporter {
+		return jaeger.NewCompositeReporter(next, reporter)
+	}}
+	defer func() { ReporterDecorators = nil }()
+
+	tracer, closer := TraceInit("test")
+	defer closer.Close()
+	tracer.StartSpan("decorated").Finish()
+
+	if spans := reporter.GetSpans(); len(spans) != 1 || spans[0].(*jaeger.Span).OperationName() != "decorated" {
+		t.Fatalf("span did not reach the decorator: %v", spans)
+	}
+}
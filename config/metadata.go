@@ -0,0 +1,87 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"strings"
+)
+
+// binarySuffix 是 gRPC 约定的二进制 metadata key 后缀，这类值在 MD 中保存原始字节，由 gRPC 负责在线路上做 base64
+const binarySuffix = "-bin"
+
+// BinaryMetadataKey 是 opentracing.Binary 格式的 span 上下文在 metadata 中的 key
+const BinaryMetadataKey = "ot-span-context-bin"
+
+// MDReaderWriter 让 metadata.MD 可以作为 opentracing.TextMap 载体。
+// key 一律小写；-bin 结尾的 key 对外以标准 base64 文本表示，在 MD 中保存解码后的字节。
+type MDReaderWriter struct {
+	metadata.MD
+}
+
+// ForeachKey 实现opentracing.TextMapReader
+func (c MDReaderWriter) ForeachKey(handler func(key, val string) error) error {
+	for k, vs := range c.MD {
+		k = strings.ToLower(k)
+		for _, v := range vs {
+			if strings.HasSuffix(k, binarySuffix) {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Set 实现 opentracing.TextMapWriter 接口，-bin 结尾的 key 的值应当是 base64 文本
+func (c MDReaderWriter) Set(key, val string) {
+	key = strings.ToLower(key)
+	if strings.HasSuffix(key, binarySuffix) {
+		val = decodeBinary(val)
+	}
+	c.MD[key] = append(c.MD[key], val)
+}
+
+// decodeBinary 和 gRPC 一样同时接受带填充和不带填充的 base64，无法解码时原样保存
+func decodeBinary(v string) string {
+	if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+		return string(b)
+	}
+	if b, err := base64.RawStdEncoding.DecodeString(v); err == nil {
+		return string(b)
+	}
+	return v
+}
+
+// MDBinaryCarrier 让 metadata.MD 可以作为 opentracing.Binary 载体：
+// Inject 时作为 io.Writer 写入 BinaryMetadataKey，Extract 时作为 io.Reader 读出
+type MDBinaryCarrier struct {
+	MD     metadata.MD
+	reader io.Reader
+}
+
+// Write 把 tracer 写出的字节追加到 BinaryMetadataKey 上
+func (c *MDBinaryCarrier) Write(p []byte) (int, error) {
+	var value string
+	if vs := c.MD[BinaryMetadataKey]; len(vs) > 0 {
+		value = vs[0]
+	}
+	c.MD[BinaryMetadataKey] = []string{value + string(p)}
+	return len(p), nil
+}
+
+// Read 读取 BinaryMetadataKey 的值，没有时返回 opentracing.ErrSpanContextNotFound
+func (c *MDBinaryCarrier) Read(p []byte) (int, error) {
+	if c.reader == nil {
+		vs := c.MD.Get(BinaryMetadataKey)
+		if len(vs) == 0 {
+			return 0, opentracing.ErrSpanContextNotFound
+		}
+		c.reader = bytes.NewReader([]byte(vs[0]))
+	}
+	return c.reader.Read(p)
+}
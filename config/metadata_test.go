@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/base64"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc/metadata"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func collect(t *testing.T, c MDReaderWriter) map[string][]string {
+	got := map[string][]string{}
+	if err := c.ForeachKey(func(k, v string) error {
+		got[k] = append(got[k], v)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, vs := range got {
+		sort.Strings(vs)
+	}
+	return got
+}
+
+func TestMDReaderWriterMultiValueAndCase(t *testing.T) {
+	md := metadata.MD{"X-Request-Id": {"b", "a"}}
+	c := MDReaderWriter{MD: md}
+	c.Set("Uber-Trace-Id", "1:2:0:1")
+	c.Set("uberctx-tenant", "acme")
+	c.Set("UBERCTX-TENANT", "globex")
+
+	want := map[string][]string{
+		"x-request-id":   {"a", "b"},
+		"uber-trace-id":  {"1:2:0:1"},
+		"uberctx-tenant": {"acme", "globex"},
+	}
+	if got := collect(t, c); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := md.Get("UBER-TRACE-ID"); len(got) != 1 {
+		t.Fatalf("key not lower-cased on Set: %v", md)
+	}
+}
+
+func TestMDReaderWriterBinary(t *testing.T) {
+	raw := string([]byte{0x00, 0xff, 0x10, 0x7f})
+	md := metadata.MD{}
+	c := MDReaderWriter{MD: md}
+	c.Set("Trace-Bin", base64.StdEncoding.EncodeToString([]byte(raw)))
+	c.Set("trace-bin", base64.RawStdEncoding.EncodeToString([]byte(raw)))
+
+	if vs := md["trace-bin"]; len(vs) != 2 || vs[0] != raw || vs[1] != raw {
+		t.Fatalf("binary values not decoded: %q", vs)
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(raw))
+	if got := collect(t, c)["trace-bin"]; !reflect.DeepEqual(got, []string{encoded, encoded}) {
+		t.Fatalf("binary values not encoded: %v", got)
+	}
+}
+
+// TestPropagatorsRoundTrip 验证支持的每种传播格式都能经过 metadata.MD 往返
+func TestPropagatorsRoundTrip(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter(),
+		jaeger.TracerOptions.Injector("b3", ZipkinPropagator),
+		jaeger.TracerOptions.Extractor("b3", ZipkinPropagator))
+	defer closer.Close()
+
+	span := tracer.StartSpan("client")
+	span.SetBaggageItem(BaggageTenant, "acme")
+	want := span.Context().(jaeger.SpanContext)
+
+	cases := []struct {
+		name    string
+		format  interface{}
+		inject  func(md metadata.MD) interface{}
+		extract func(md metadata.MD) interface{}
+	}{
+		{"text map", opentracing.TextMap,
+			func(md metadata.MD) interface{} { return MDReaderWriter{MD: md} },
+			func(md metadata.MD) interface{} { return MDReaderWriter{MD: md} }},
+		{"http headers", opentracing.HTTPHeaders,
+			func(md metadata.MD) interface{} { return MDReaderWriter{MD: md} },
+			func(md metadata.MD) interface{} { return MDReaderWriter{MD: md} }},
+		{"baggage filtered", opentracing.TextMap,
+			func(md metadata.MD) interface{} { return &BaggageWriter{TextMapWriter: MDReaderWriter{MD: md}} },
+			func(md metadata.MD) interface{} { return BaggageReader{TextMapReader: MDReaderWriter{MD: md}} }},
+		{"b3", "b3",
+			func(md metadata.MD) interface{} { return MDReaderWriter{MD: md} },
+			func(md metadata.MD) interface{} { return MDReaderWriter{MD: md} }},
+		{"binary", opentracing.Binary,
+			func(md metadata.MD) interface{} { return &MDBinaryCarrier{MD: md} },
+			func(md metadata.MD) interface{} { return &MDBinaryCarrier{MD: md} }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if err := tracer.Inject(span.Context(), tc.format, tc.inject(md)); err != nil {
+				t.Fatal(err)
+			}
+			sc, err := tracer.Extract(tc.format, tc.extract(md))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := sc.(jaeger.SpanContext)
+			if got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			if server := tracer.StartSpan("server", opentracing.ChildOf(sc)); server.BaggageItem(BaggageTenant) != "acme" {
+				t.Fatalf("baggage lost: %v", md)
+			}
+		})
+	}
+}
+
+func TestMDBinaryCarrierMissing(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+
+	if _, err := tracer.Extract(opentracing.Binary, &MDBinaryCarrier{MD: metadata.MD{}}); err == nil {
+		t.Fatal("expected error for missing binary context")
+	}
+}
@@ -0,0 +1,194 @@
+// Package critpath 计算一个 trace 的关键路径：从根 span 结束时间往回走，每一段时间归给当时
+// 最晚结束的子 span，并行的子 span 中只有最慢的那个在路径上。同时计算每个 span 的自身耗时
+// （去掉子 span 覆盖的时间），用来判断先优化哪个调用。FollowsFrom 的子 span 不阻塞父 span，不参与计算。
+package critpath
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"opentracing-sample/spanstore"
+	"sort"
+	"time"
+)
+
+// ErrNoRoot 表示 span 中找不到根 span
+var ErrNoRoot = errors.New("critpath: no root span")
+
+// Segment 是关键路径上的一段，这段时间内 trace 的耗时由该 span 自身决定
+type Segment struct {
+	SpanID    string        `json:"span_id"`
+	Service   string        `json:"service"`
+	Operation string        `json:"operation"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// SpanStat 是单个 span 的耗时，Critical 是它在关键路径上的总时间
+type SpanStat struct {
+	SpanID    string        `json:"span_id"`
+	Service   string        `json:"service"`
+	Operation string        `json:"operation"`
+	Duration  time.Duration `json:"duration"`
+	SelfTime  time.Duration `json:"self_time"`
+	Critical  time.Duration `json:"critical"`
+}
+
+// Report 是一个 trace 的分析结果，Spans 按 Critical 从大到小排序
+type Report struct {
+	TraceID  string        `json:"trace_id"`
+	Root     string        `json:"root"`
+	Duration time.Duration `json:"duration"`
+	Path     []Segment     `json:"path"`
+	Spans    []SpanStat    `json:"spans"`
+}
+
+type node struct {
+	span     spanstore.Span
+	children []*node
+}
+
+// Analyze 分析属于同一个 trace 的 span。父 span 不在其中的都算作根，取最早开始的那个
+func Analyze(spans []spanstore.Span) (*Report, error) {
+	nodes := make(map[string]*node, len(spans))
+	for _, s := range spans {
+		nodes[s.SpanID] = &node{span: s}
+	}
+	var root *node
+	for _, s := range spans {
+		n := nodes[s.SpanID]
+		if parent, ok := nodes[s.ParentID]; ok && s.ParentID != s.SpanID {
+			if !s.FollowsFrom {
+				parent.children = append(parent.children, n)
+			}
+			continue
+		}
+		if root == nil || s.Start.Before(root.span.Start) {
+			root = n
+		}
+	}
+	if root == nil {
+		return nil, ErrNoRoot
+	}
+
+	r := &Report{TraceID: root.span.TraceID, Root: root.span.Operation, Duration: root.span.Duration}
+	critical := map[string]time.Duration{}
+	walk(root, root.span.End(), func(n *node, start, end time.Time) {
+		r.Path = append(r.Path, Segment{
+			SpanID:    n.span.SpanID,
+			Service:   n.span.Service,
+			Operation: n.span.Operation,
+			Start:     start,
+			Duration:  end.Sub(start),
+		})
+		critical[n.span.SpanID] += end.Sub(start)
+	})
+	// walk 从后往前产生分段
+	for i, j := 0, len(r.Path)-1; i < j; i, j = i+1, j-1 {
+		r.Path[i], r.Path[j] = r.Path[j], r.Path[i]
+	}
+
+	var stats func(n *node)
+	stats = func(n *node) {
+		r.Spans = append(r.Spans, SpanStat{
+			SpanID:    n.span.SpanID,
+			Service:   n.span.Service,
+			Operation: n.span.Operation,
+			Duration:  n.span.Duration,
+			SelfTime:  selfTime(n),
+			Critical:  critical[n.span.SpanID],
+		})
+		for _, c := range n.children {
+			stats(c)
+		}
+	}
+	stats(root)
+	sort.SliceStable(r.Spans, func(i, j int) bool { return r.Spans[i].Critical > r.Spans[j].Critical })
+	return r, nil
+}
+
+// walk 计算 n 在 until 之前的关键路径：每次取在游标之前开始、结束最晚的子 span，
+// 它结束之后到游标的时间归 n 自己，然后递归进入该子 span，游标移到它的开始时间
+func walk(n *node, until time.Time, emit func(n *node, start, end time.Time)) {
+	cursor := minTime(n.span.End(), until)
+	for cursor.After(n.span.Start) {
+		var next *node
+		var nextEnd time.Time
+		for _, c := range n.children {
+			if !c.span.Start.Before(cursor) {
+				continue
+			}
+			end := minTime(c.span.End(), cursor)
+			if next == nil || end.After(nextEnd) {
+				next, nextEnd = c, end
+			}
+		}
+		if next == nil {
+			emit(n, n.span.Start, cursor)
+			return
+		}
+		if nextEnd.Before(cursor) {
+			emit(n, nextEnd, cursor)
+		}
+		walk(next, nextEnd, emit)
+		cursor = next.span.Start
+	}
+}
+
+// selfTime 是 n 的耗时减去子 span 覆盖的时间，子 span 超出 n 的部分不计
+func selfTime(n *node) time.Duration {
+	type interval struct{ start, end time.Time }
+	var covered []interval
+	for _, c := range n.children {
+		start, end := maxTime(c.span.Start, n.span.Start), minTime(c.span.End(), n.span.End())
+		if end.After(start) {
+			covered = append(covered, interval{start, end})
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i].start.Before(covered[j].start) })
+
+	self := n.span.Duration
+	var last time.Time
+	for _, iv := range covered {
+		if iv.start.Before(last) {
+			iv.start = last
+		}
+		if iv.end.After(iv.start) {
+			self -= iv.end.Sub(iv.start)
+			last = iv.end
+		}
+	}
+	return self
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Handler 返回调试端点，按 trace_id 参数从 store 中取出 trace 并以 JSON 输出分析结果
+func Handler(store *spanstore.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("trace_id")
+		if id == "" {
+			http.Error(w, "trace_id is required", http.StatusBadRequest)
+			return
+		}
+		report, err := Analyze(store.Trace(id))
+		if err != nil {
+			http.Error(w, "trace not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+}
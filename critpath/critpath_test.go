@@ -0,0 +1,119 @@
+package critpath
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"opentracing-sample/spanstore"
+	"testing"
+	"time"
+)
+
+var t0 = time.Unix(0, 0)
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func span(id, parent, op string, start, end int) spanstore.Span {
+	return spanstore.Span{TraceID: "t", SpanID: id, ParentID: parent, Service: "gin-sample-tracing",
+		Operation: op, Start: t0.Add(ms(start)), Duration: ms(end - start)}
+}
+
+// productTrace 是 /api/product/:id 的形状：三个分支并行，listReviews 最慢
+func productTrace() []spanstore.Span {
+	job := span("j", "r", "job recomputeRating", 100, 300)
+	job.FollowsFrom = true
+	return []spanstore.Span{
+		span("r", "", "/api/product/:id", 0, 100),
+		span("h", "r", "getProduceDetails", 2, 90),
+		span("c", "h", "checkToken", 5, 40),
+		span("g", "c", "call gRPC", 10, 38),
+		span("p", "h", "getProduct", 5, 30),
+		span("l", "h", "listReviews", 5, 60),
+		job,
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	r, err := Analyze(productTrace())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Root != "/api/product/:id" || r.Duration != ms(100) {
+		t.Fatalf("unexpected root %+v", r)
+	}
+	want := []struct {
+		op         string
+		start, dur int
+	}{
+		{"/api/product/:id", 0, 2},
+		{"getProduceDetails", 2, 3},
+		{"listReviews", 5, 55},
+		{"getProduceDetails", 60, 30},
+		{"/api/product/:id", 90, 10},
+	}
+	if len(r.Path) != len(want) {
+		t.Fatalf("unexpected path %+v", r.Path)
+	}
+	for i, w := range want {
+		s := r.Path[i]
+		if s.Operation != w.op || !s.Start.Equal(t0.Add(ms(w.start))) || s.Duration != ms(w.dur) {
+			t.Errorf("segment %d = %s@%v %v, want %s@%d %dms", i, s.Operation, s.Start.Sub(t0), s.Duration, w.op, w.start, w.dur)
+		}
+	}
+
+	stats := map[string]SpanStat{}
+	for _, s := range r.Spans {
+		stats[s.Operation] = s
+	}
+	if r.Spans[0].Operation != "listReviews" || stats["listReviews"].Critical != ms(55) {
+		t.Errorf("listReviews should dominate the critical path: %+v", r.Spans)
+	}
+	if stats["getProduceDetails"].SelfTime != ms(33) || stats["checkToken"].SelfTime != ms(7) ||
+		stats["/api/product/:id"].SelfTime != ms(12) || stats["checkToken"].Critical != 0 {
+		t.Errorf("unexpected self times: %+v", stats)
+	}
+	if _, ok := stats["job recomputeRating"]; ok {
+		t.Error("follows-from spans should not be part of the analysis")
+	}
+}
+
+func TestSequentialAndOverrunningChildren(t *testing.T) {
+	r, err := Analyze([]spanstore.Span{
+		span("r", "", "root", 0, 40),
+		span("a", "r", "a", 0, 10),
+		span("b", "r", "b", 10, 50),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Path) != 2 || r.Path[0].Operation != "a" || r.Path[1].Operation != "b" || r.Path[1].Duration != ms(30) {
+		t.Fatalf("unexpected path %+v", r.Path)
+	}
+	if _, err := Analyze(nil); err != ErrNoRoot {
+		t.Fatalf("expected ErrNoRoot, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	store := spanstore.NewStore(100)
+	for _, s := range productTrace() {
+		store.Consume(s)
+	}
+	h := Handler(store)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/critical-path?trace_id=t", nil))
+	var r Report
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil || len(r.Path) != 5 {
+		t.Fatalf("unexpected report %+v, %v", r, err)
+	}
+	for target, code := range map[string]int{"/debug/critical-path": http.StatusBadRequest, "/debug/critical-path?trace_id=x": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != code {
+			t.Errorf("%s: got %d, want %d", target, rec.Code, code)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Package depgraph 从已结束的 span 构建服务依赖图。客户端 span（client、producer）和
+// 服务端 span（server、consumer）按父子 ID 配对成一条调用边：服务端 span 的父 span 就是客户端 span。
+// 只能看到一侧时（对端由其他进程上报），超时后按 peer.service 等标签或 unknown 记录。
+package depgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"opentracing-sample/spanstore"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Unknown 是无法确定对端服务时使用的名字
+const Unknown = "unknown"
+
+// DefaultTimeout 是等待另一侧 span 的时间
+const DefaultTimeout = 30 * time.Second
+
+// maxSamples 是每条边保留的延迟样本数，百分位按最近的样本计算
+const maxSamples = 1024
+
+// Edge 是两个服务之间的调用统计，延迟以客户端 span 为准，单位毫秒
+type Edge struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Calls     int     `json:"calls"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+}
+
+type edgeKey struct {
+	from, to string
+}
+
+type edgeStats struct {
+	calls, errors int
+	samples       []time.Duration
+	next          int
+}
+
+func (e *edgeStats) add(d time.Duration, failed bool) {
+	e.calls++
+	if failed {
+		e.errors++
+	}
+	if len(e.samples) < maxSamples {
+		e.samples = append(e.samples, d)
+		return
+	}
+	e.samples[e.next] = d
+	e.next = (e.next + 1) % maxSamples
+}
+
+type pending struct {
+	span    spanstore.Span
+	arrived time.Time
+}
+
+// Graph 是实时的依赖图，实现 spanstore.Sink
+type Graph struct {
+	timeout time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	edges   map[edgeKey]*edgeStats
+	clients map[string]pending // 按客户端 span 的 trace ID + span ID 索引
+	servers map[string]pending // 按服务端 span 的 trace ID + 父 span ID 索引
+	expired time.Time          // 上次检查超时的时间
+}
+
+// New 创建依赖图，timeout 是等待另一侧 span 的时间，0 表示 DefaultTimeout
+func New(timeout time.Duration) *Graph {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Graph{
+		timeout: timeout,
+		now:     time.Now,
+		edges:   map[edgeKey]*edgeStats{},
+		clients: map[string]pending{},
+		servers: map[string]pending{},
+	}
+}
+
+func isClient(s spanstore.Span) bool {
+	return s.Kind() == "client" || s.Kind() == "producer"
+}
+
+func isServer(s spanstore.Span) bool {
+	return s.Kind() == "server" || s.Kind() == "consumer"
+}
+
+// peer 返回客户端 span 上标注的对端，数据库和缓存的 span 以 db.type 作为服务名
+func peer(s spanstore.Span) string {
+	for _, key := range []string{"peer.service", "db.type", "peer.hostname", "peer.address"} {
+		if v := s.Tag(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (g *Graph) Consume(s spanstore.Span) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire()
+
+	switch {
+	case isClient(s):
+		// 数据库等没有服务端 span，直接记录
+		if s.Tag("db.type") != "" {
+			g.record(s.Service, peer(s), s, false)
+			return
+		}
+		key := s.TraceID + "/" + s.SpanID
+		if srv, ok := g.servers[key]; ok {
+			delete(g.servers, key)
+			g.record(s.Service, srv.span.Service, s, srv.span.Error())
+			return
+		}
+		g.clients[key] = pending{span: s, arrived: g.now()}
+	case isServer(s) && s.ParentID != "":
+		key := s.TraceID + "/" + s.ParentID
+		if cli, ok := g.clients[key]; ok {
+			delete(g.clients, key)
+			g.record(cli.span.Service, s.Service, cli.span, s.Error())
+			return
+		}
+		g.servers[key] = pending{span: s, arrived: g.now()}
+	}
+}
+
+// record 以客户端 span 的耗时和错误记录一次调用，服务端出错也算作失败
+func (g *Graph) record(from, to string, client spanstore.Span, serverFailed bool) {
+	if to == "" {
+		to = Unknown
+	}
+	k := edgeKey{from: from, to: to}
+	e, ok := g.edges[k]
+	if !ok {
+		e = &edgeStats{}
+		g.edges[k] = e
+	}
+	e.add(client.Duration, client.Error() || serverFailed)
+}
+
+// expire 把超时仍未配对的 span 按一侧的信息记录下来。每次都要遍历所有未配对的 span，
+// 所以最多每 timeout/10 检查一次，span 最多在超时之后再晚 10% 记录
+func (g *Graph) expire() {
+	now := g.now()
+	if now.Sub(g.expired) < g.timeout/10 {
+		return
+	}
+	g.expired = now
+	deadline := now.Add(-g.timeout)
+	for k, p := range g.clients {
+		if p.arrived.Before(deadline) {
+			delete(g.clients, k)
+			g.record(p.span.Service, peer(p.span), p.span, false)
+		}
+	}
+	for k, p := range g.servers {
+		if p.arrived.Before(deadline) {
+			delete(g.servers, k)
+			g.record(Unknown, p.span.Service, p.span, false)
+		}
+	}
+}
+
+// Edges 返回按 From、To 排序的所有边
+func (g *Graph) Edges() []Edge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire()
+
+	edges := make([]Edge, 0, len(g.edges))
+	for k, e := range g.edges {
+		samples := spanstore.SortDurations(append([]time.Duration{}, e.samples...))
+		edges = append(edges, Edge{
+			From:      k.from,
+			To:        k.to,
+			Calls:     e.calls,
+			Errors:    e.errors,
+			ErrorRate: float64(e.errors) / float64(e.calls),
+			P50:       millis(spanstore.Percentile(samples, 0.5)),
+			P90:       millis(spanstore.Percentile(samples, 0.9)),
+			P99:       millis(spanstore.Percentile(samples, 0.99)),
+		})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteDOT 以 Graphviz DOT 格式输出依赖图，边上标注调用次数、错误率和 p99
+func WriteDOT(w io.Writer, edges []Edge) error {
+	if _, err := fmt.Fprintln(w, "digraph dependencies {"); err != nil {
+		return err
+	}
+	for _, e := range edges {
+		label := fmt.Sprintf("%d calls\\n%.1f%% errors\\np99 %.1fms", e.Calls, e.ErrorRate*100, e.P99)
+		if _, err := fmt.Fprintf(w, "  %q -> %q [label=\"%s\"];\n", e.From, e.To, label); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// ServeHTTP 默认以 JSON 输出所有边，format=dot 时输出 Graphviz DOT
+func (g *Graph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	edges := g.Edges()
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		WriteDOT(w, edges)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(edges)
+}
@@ -0,0 +1,112 @@
+package depgraph
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"opentracing-sample/spanstore"
+	"strings"
+	"testing"
+	"time"
+)
+
+func span(service, kind, id, parent string, d time.Duration, tags map[string]interface{}) spanstore.Span {
+	if tags == nil {
+		tags = map[string]interface{}{}
+	}
+	tags["span.kind"] = kind
+	return spanstore.Span{TraceID: "t", SpanID: id, ParentID: parent, Service: service, Duration: d, Tags: tags}
+}
+
+func TestPairsClientAndServerSpans(t *testing.T) {
+	g := New(time.Minute)
+	// 同一进程中服务端 span 先结束
+	g.Consume(span("auth-api-grpc", "server", "2", "1", 5*time.Millisecond, nil))
+	g.Consume(span("gin-sample-tracing", "client", "1", "0", 8*time.Millisecond, nil))
+	// 另一个调用客户端先到，服务端出错
+	g.Consume(span("gin-sample-tracing", "client", "3", "0", 20*time.Millisecond, nil))
+	g.Consume(span("auth-api-grpc", "server", "4", "3", 15*time.Millisecond, map[string]interface{}{"error": true}))
+	g.Consume(span("gin-sample-tracing", "client", "5", "0", time.Millisecond, map[string]interface{}{"db.type": "sqlite"}))
+	g.Consume(span("gin-sample-tracing", "server", "0", "", 30*time.Millisecond, nil))
+
+	edges := g.Edges()
+	if len(edges) != 2 {
+		t.Fatalf("unexpected edges %+v", edges)
+	}
+	grpc, db := edges[0], edges[1]
+	if grpc.From != "gin-sample-tracing" || grpc.To != "auth-api-grpc" || grpc.Calls != 2 || grpc.Errors != 1 || grpc.ErrorRate != 0.5 {
+		t.Errorf("unexpected grpc edge %+v", grpc)
+	}
+	if grpc.P50 != 8 || grpc.P99 != 20 {
+		t.Errorf("latency should come from the client spans: %+v", grpc)
+	}
+	if db.To != "sqlite" || db.Calls != 1 {
+		t.Errorf("unexpected db edge %+v", db)
+	}
+}
+
+func TestUnmatchedSpansExpire(t *testing.T) {
+	now := time.Unix(0, 0)
+	g := New(time.Second)
+	g.now = func() time.Time { return now }
+
+	g.Consume(span("gin-sample-tracing", "client", "1", "0", time.Millisecond, map[string]interface{}{"peer.address": "localhost:50051"}))
+	g.Consume(span("auth-api-grpc", "server", "9", "8", time.Millisecond, nil))
+	if len(g.Edges()) != 0 {
+		t.Fatal("spans recorded before the timeout")
+	}
+	now = now.Add(2 * time.Second)
+	edges := g.Edges()
+	if len(edges) != 2 || edges[0].From != "gin-sample-tracing" || edges[0].To != "localhost:50051" ||
+		edges[1].From != Unknown || edges[1].To != "auth-api-grpc" {
+		t.Fatalf("unexpected edges %+v", edges)
+	}
+}
+
+func TestExpireInterval(t *testing.T) {
+	now := time.Unix(0, 0)
+	g := New(time.Second)
+	g.now = func() time.Time { return now }
+	client := func(id string) spanstore.Span {
+		return span("gin-sample-tracing", "client", id, "0", time.Millisecond, map[string]interface{}{"peer.service": "auth-api-grpc"})
+	}
+
+	g.Consume(client("1"))
+	now = now.Add(50 * time.Millisecond)
+	g.Consume(client("2"))
+	now = now.Add(time.Second)
+	g.Consume(client("3"))
+	if _, ok := g.clients["t/1"]; ok || len(g.clients) != 2 {
+		t.Fatalf("expected only the first span to expire, pending %v", g.clients)
+	}
+	// 距上次检查不到 timeout/10，第二个 span 虽已超时也不检查
+	now = now.Add(50 * time.Millisecond)
+	g.Consume(client("4"))
+	if len(g.clients) != 3 {
+		t.Fatalf("expired again within the interval, pending %d", len(g.clients))
+	}
+	now = now.Add(50 * time.Millisecond)
+	g.Consume(client("5"))
+	if len(g.clients) != 3 {
+		t.Fatalf("expected the second span to expire, pending %d", len(g.clients))
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	g := New(time.Minute)
+	g.Consume(span("auth-api-grpc", "server", "2", "1", time.Millisecond, nil))
+	g.Consume(span("gin-sample-tracing", "client", "1", "0", time.Millisecond, nil))
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/dependencies", nil))
+	var edges []Edge
+	if err := json.NewDecoder(rec.Body).Decode(&edges); err != nil || len(edges) != 1 || edges[0].Calls != 1 {
+		t.Fatalf("unexpected JSON %+v, %v", edges, err)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/dependencies?format=dot", nil))
+	dot := rec.Body.String()
+	if !strings.HasPrefix(dot, "digraph") || !strings.Contains(dot, `"gin-sample-tracing" -> "auth-api-grpc" [label="1 calls\n0.0% errors\np99 1.0ms"]`) {
+		t.Fatalf("unexpected DOT:\n%s", dot)
+	}
+}
@@ -0,0 +1,39 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// LoadFile 读取 JSON 格式的故障注入配置
+func LoadFile(path string) (Config, error) {
+	var cfg Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("fault: parse %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// FromEnv 只有 FAULT_INJECTION 为 true 时才启用，规则从 FAULT_CONFIG 指向的文件读取
+func FromEnv() (*Injector, error) {
+	enabled, _ := strconv.ParseBool(os.Getenv("FAULT_INJECTION"))
+	if !enabled {
+		return Disabled(), nil
+	}
+	cfg := Config{}
+	if path := os.Getenv("FAULT_CONFIG"); path != "" {
+		var err error
+		if cfg, err = LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Enabled = true
+	return New(cfg)
+}
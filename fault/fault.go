@@ -0,0 +1,210 @@
+// Package fault 为混沌测试在 gin 中间件和 gRPC 拦截器中注入故障：延迟、中止或丢弃请求。
+// 故障可以由 x-fault 头、fault baggage 或按比例的规则触发，注入的故障会打在 span 上。
+// 默认关闭，需要显式启用。
+package fault
+
+import (
+	"context"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"math/rand"
+	"opentracing-sample/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header 是触发故障的请求头或 gRPC metadata key，值的格式见 Parse
+const Header = "x-fault"
+
+// span 标签
+const (
+	TagInjected = "fault.injected"
+	TagSource   = "fault.source"
+	TagSpec     = "fault.spec"
+)
+
+// 故障的来源
+const (
+	SourceHeader  = "header"
+	SourceBaggage = "baggage"
+	SourceRule    = "rule"
+)
+
+// Fault 描述一次注入的故障，延迟会先于中止或丢弃执行
+type Fault struct {
+	Delay time.Duration
+	Abort int
+	Drop  bool
+}
+
+func (f Fault) empty() bool {
+	return f.Delay == 0 && f.Abort == 0 && !f.Drop
+}
+
+// String 返回可以被 Parse 解析的形式
+func (f Fault) String() string {
+	var parts []string
+	if f.Delay > 0 {
+		parts = append(parts, "delay="+f.Delay.String())
+	}
+	if f.Abort != 0 {
+		parts = append(parts, "abort="+strconv.Itoa(f.Abort))
+	}
+	if f.Drop {
+		parts = append(parts, "drop")
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse 解析 "delay=500ms"、"abort=503"、"drop" 以及用逗号组合的形式，如 "delay=200ms,abort=500"。
+// abort 小于 100 时是 gRPC 状态码（1 到 16），否则是 HTTP 状态码（100 到 599），两边按 Code 和 HTTPStatus 互相换算。
+func Parse(spec string) (Fault, error) {
+	var f Fault
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		var err error
+		switch {
+		case kv[0] == "delay" && len(kv) == 2:
+			f.Delay, err = time.ParseDuration(kv[1])
+		case kv[0] == "abort" && len(kv) == 2:
+			f.Abort, err = strconv.Atoi(kv[1])
+		case kv[0] == "drop" && len(kv) == 1:
+			f.Drop = true
+		default:
+			err = fmt.Errorf("unknown fault %q", part)
+		}
+		if err != nil || f.Delay < 0 || !validAbort(f.Abort) {
+			return Fault{}, fmt.Errorf("fault: invalid spec %q", spec)
+		}
+	}
+	return f, nil
+}
+
+// Rule 按比例为匹配的路由或 gRPC 方法注入故障，Match 以 * 结尾表示前缀匹配
+type Rule struct {
+	Match      string  `json:"match"`
+	Percentage float64 `json:"percentage"`
+	Fault      string  `json:"fault"`
+}
+
+func (r Rule) matches(route string) bool {
+	if strings.HasSuffix(r.Match, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(r.Match, "*"))
+	}
+	return r.Match == route
+}
+
+// Config 是故障注入的配置，Enabled 为 false 时头、baggage 和规则都不生效
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Rules   []Rule `json:"rules"`
+}
+
+type rule struct {
+	Rule
+	fault Fault
+}
+
+// Injector 决定一次请求要注入的故障，可以并发使用
+type Injector struct {
+	mu      sync.RWMutex
+	enabled bool
+	rules   []rule
+	rand    func() float64
+}
+
+// New 按 cfg 创建 Injector，规则中的故障格式错误时返回错误
+func New(cfg Config) (*Injector, error) {
+	i := &Injector{rand: rand.Float64}
+	if err := i.Update(cfg); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Disabled 返回不注入任何故障的 Injector
+func Disabled() *Injector {
+	return &Injector{rand: rand.Float64}
+}
+
+// Update 替换配置，可以在运行时调用
+func (i *Injector) Update(cfg Config) error {
+	rules := make([]rule, len(cfg.Rules))
+	for n, r := range cfg.Rules {
+		f, err := Parse(r.Fault)
+		if err != nil {
+			return err
+		}
+		rules[n] = rule{Rule: r, fault: f}
+	}
+	i.mu.Lock()
+	i.enabled = cfg.Enabled
+	i.rules = rules
+	i.mu.Unlock()
+	return nil
+}
+
+// Resolve 依次检查请求头、baggage 和规则，返回要注入的故障和来源；没有故障时 ok 为 false
+func (i *Injector) Resolve(ctx context.Context, route, header string) (f Fault, source string, ok bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.enabled {
+		return Fault{}, "", false
+	}
+
+	for _, c := range []struct{ spec, source string }{
+		{header, SourceHeader},
+		{config.GetBaggage(ctx, config.BaggageFault), SourceBaggage},
+	} {
+		if c.spec == "" {
+			continue
+		}
+		f, err := Parse(c.spec)
+		if err != nil {
+			config.Log.WithField("component", "fault").Warn(err)
+			continue
+		}
+		if !f.empty() {
+			return f, c.source, true
+		}
+	}
+	for _, r := range i.rules {
+		if r.matches(route) && i.rand()*100 < r.Percentage {
+			return r.fault, SourceRule, true
+		}
+	}
+	return Fault{}, "", false
+}
+
+// Tag 把注入的故障记录到 span 上，与真实故障区分开
+func Tag(span opentracing.Span, f Fault, source string) {
+	if span == nil {
+		return
+	}
+	span.SetTag(TagInjected, true)
+	span.SetTag(TagSource, source)
+	span.SetTag(TagSpec, f.String())
+	span.LogFields(log.String("event", "fault injected"), log.String("fault", f.String()), log.String("source", source))
+}
+
+// Sleep 执行故障中的延迟，上下文结束时提前返回 false
+func (f Fault) Sleep(ctx context.Context) bool {
+	if f.Delay == 0 {
+		return true
+	}
+	t := time.NewTimer(f.Delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
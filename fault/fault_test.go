@@ -0,0 +1,117 @@
+package fault
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"opentracing-sample/config"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse("delay=200ms, abort=503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Delay != 200*time.Millisecond || f.Abort != 503 || f.Drop {
+		t.Fatalf("unexpected fault %+v", f)
+	}
+	if f.String() != "delay=200ms,abort=503" {
+		t.Fatalf("unexpected string %q", f.String())
+	}
+	if f, _ := Parse("drop"); !f.Drop {
+		t.Fatal("drop not parsed")
+	}
+	for _, bad := range []string{"delay=soon", "abort=-1", "abort=17", "abort=1000", "explode", "drop=1"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) should fail", bad)
+		}
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for abort, want := range map[int]int{503: 503, 14: 503, 5: 404, 2: 500} {
+		if got := (Fault{Abort: abort}).HTTPStatus(); got != want {
+			t.Errorf("abort=%d: got HTTP status %d, want %d", abort, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	if _, _, ok := Disabled().Resolve(ctx, "/api/product/:id", "abort=500"); ok {
+		t.Fatal("disabled injector should ignore the header")
+	}
+
+	i, err := New(Config{Enabled: true, Rules: []Rule{
+		{Match: "/api/product*", Percentage: 50, Fault: "abort=503"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, source, ok := i.Resolve(ctx, "/api/product/:id", "delay=1s"); !ok || source != SourceHeader || f.Delay != time.Second {
+		t.Fatalf("header fault not resolved: %+v %s", f, source)
+	}
+
+	i.rand = func() float64 { return 0.49 }
+	if f, source, ok := i.Resolve(ctx, "/api/product/:id", ""); !ok || source != SourceRule || f.Abort != 503 {
+		t.Fatalf("rule fault not resolved: %+v %s", f, source)
+	}
+	i.rand = func() float64 { return 0.51 }
+	if _, _, ok := i.Resolve(ctx, "/api/product/:id", ""); ok {
+		t.Fatal("rule should only apply to its percentage")
+	}
+	if _, _, ok := i.Resolve(ctx, "/debug/vars", ""); ok {
+		t.Fatal("rule should only apply to matching routes")
+	}
+
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	ctx = opentracing.ContextWithSpan(ctx, tracer.StartSpan("root"))
+	config.SetBaggage(ctx, config.BaggageFault, "abort=500")
+	if f, source, ok := i.Resolve(ctx, "/Greeter/SayHello", ""); !ok || source != SourceBaggage || f.Abort != 500 {
+		t.Fatalf("baggage fault not resolved: %+v %s", f, source)
+	}
+
+	if _, err := New(Config{Rules: []Rule{{Match: "*", Fault: "explode"}}}); err == nil {
+		t.Fatal("invalid rule accepted")
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	i, _ := New(Config{Enabled: true})
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("/Greeter/SayHello")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(Header, "delay=10ms,abort=503"))
+
+	called := false
+	start := time.Now()
+	_, err := UnaryServerInterceptor(i)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/Greeter/SayHello"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { called = true; return nil, nil })
+	if status.Code(err) != codes.Unavailable || called {
+		t.Fatalf("expected injected Unavailable, got %v", err)
+	}
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("delay not applied")
+	}
+	span.Finish()
+	tags := tracer.FinishedSpans()[0].Tags()
+	if tags[TagInjected] != true || tags[TagSource] != SourceHeader || tags[TagSpec] != "delay=10ms,abort=503" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	dropCtx, cancel := context.WithTimeout(metadata.NewIncomingContext(context.Background(), metadata.Pairs(Header, "drop")), 10*time.Millisecond)
+	defer cancel()
+	_, err = UnaryServerInterceptor(i)(dropCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/Greeter/SayHello"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("dropped call should wait for the deadline, got %v", err)
+	}
+}
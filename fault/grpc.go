@@ -0,0 +1,116 @@
+package fault
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+)
+
+// httpCodes 把 HTTP 状态码换算成 gRPC 状态码
+var httpCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusInternalServerError: codes.Internal,
+	http.StatusNotImplemented:      codes.Unimplemented,
+	http.StatusBadGateway:          codes.Unavailable,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+	http.StatusGatewayTimeout:      codes.DeadlineExceeded,
+}
+
+// httpStatuses 把 gRPC 状态码换算成 HTTP 状态码
+var httpStatuses = map[codes.Code]int{
+	codes.Canceled:           http.StatusRequestTimeout,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// validAbort 判断 abort 是否为 0（不中止）、gRPC 错误码或 net/http 能写出的 HTTP 状态码
+func validAbort(abort int) bool {
+	return abort >= 0 && abort <= int(codes.Unauthenticated) || abort >= 100 && abort <= 599
+}
+
+// Code 返回 abort 对应的 gRPC 状态码
+func (f Fault) Code() codes.Code {
+	if f.Abort < 100 {
+		return codes.Code(f.Abort)
+	}
+	if c, ok := httpCodes[f.Abort]; ok {
+		return c
+	}
+	return codes.Unknown
+}
+
+// HTTPStatus 返回 abort 对应的 HTTP 状态码
+func (f Fault) HTTPStatus() int {
+	if f.Abort >= 100 {
+		return f.Abort
+	}
+	if s, ok := httpStatuses[codes.Code(f.Abort)]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// inject 执行故障，drop 时一直等到调用方放弃
+func (i *Injector) inject(ctx context.Context, method string) error {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(Header); len(vs) > 0 {
+			header = vs[0]
+		}
+	}
+	f, source, ok := i.Resolve(ctx, method, header)
+	if !ok {
+		return nil
+	}
+	Tag(opentracing.SpanFromContext(ctx), f, source)
+
+	if !f.Sleep(ctx) || f.Drop {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if f.Abort != 0 {
+		return status.Errorf(f.Code(), "fault injected: %s", f)
+	}
+	return nil
+}
+
+// UnaryServerInterceptor 按 i 注入故障，需要放在 opentracing 拦截器之后
+func UnaryServerInterceptor(i *Injector) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := i.inject(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 是 UnaryServerInterceptor 的流式版本
+func StreamServerInterceptor(i *Injector) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := i.inject(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,76 @@
+// Package group 是 errgroup 风格的并发工具：每个分支在自己的子 span 中并行执行，
+// 第一个失败的分支取消其余分支，并记录在父 span 上
+package group
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"sync"
+)
+
+// TagFailed 是父 span 上记录第一个失败分支名字的标签
+const TagFailed = "group.failed"
+
+// Group 并行运行一组分支，零值不可用，需要通过 WithContext 创建
+type Group struct {
+	ctx    context.Context
+	parent opentracing.Span
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	once   sync.Once
+	err    error
+	failed string
+}
+
+// WithContext 返回以 ctx 中的 span 为父 span 的 Group，以及在第一个分支失败时被取消的上下文
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{ctx: ctx, parent: opentracing.SpanFromContext(ctx), cancel: cancel}, ctx
+}
+
+// Go 在新的 goroutine 中运行 fn，fn 收到的上下文带有名为 name 的子 span
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		ctx := g.ctx
+		var span opentracing.Span
+		if g.parent != nil {
+			span = g.parent.Tracer().StartSpan(name, opentracing.ChildOf(g.parent.Context()))
+			ctx = opentracing.ContextWithSpan(ctx, span)
+			defer span.Finish()
+		}
+		err := fn(ctx)
+		if err == nil {
+			return
+		}
+		if span != nil {
+			ext.Error.Set(span, true)
+			span.SetTag("error.message", err.Error())
+		}
+		g.once.Do(func() {
+			g.err = err
+			g.failed = name
+			g.cancel()
+		})
+	}()
+}
+
+// Wait 等待所有分支结束，返回第一个错误，并把失败的分支记录在父 span 上
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	if g.err != nil && g.parent != nil {
+		g.parent.SetTag(TagFailed, g.failed)
+		g.parent.LogFields(
+			log.String("event", "branch failed"),
+			log.String("branch", g.failed),
+			log.Error(g.err),
+		)
+	}
+	return g.err
+}
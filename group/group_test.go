@@ -0,0 +1,84 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"testing"
+	"time"
+)
+
+func TestParallelBranches(t *testing.T) {
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("getProduceDetails")
+	g, _ := WithContext(opentracing.ContextWithSpan(context.Background(), parent))
+
+	start := time.Now()
+	for _, name := range []string{"checkToken", "getProduct", "listReviews"} {
+		g.Go(name, func(ctx context.Context) error {
+			if opentracing.SpanFromContext(ctx) == nil {
+				return errors.New("branch span missing")
+			}
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("branches did not run in parallel: %v", elapsed)
+	}
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans, got %d", len(spans))
+	}
+	for _, s := range spans[:3] {
+		if s.ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+			t.Fatalf("%s is not a child of the parent span", s.OperationName)
+		}
+	}
+	if parent.(*mocktracer.MockSpan).Tag(TagFailed) != nil {
+		t.Fatal("no branch failed")
+	}
+}
+
+func TestFirstErrorCancelsSiblings(t *testing.T) {
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("getProduceDetails")
+	g, ctx := WithContext(opentracing.ContextWithSpan(context.Background(), parent))
+
+	boom := errors.New("token rejected")
+	g.Go("checkToken", func(ctx context.Context) error { return boom })
+	g.Go("getProduct", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return errors.New("sibling not cancelled")
+		}
+	})
+	if err := g.Wait(); err != boom {
+		t.Fatalf("expected first error, got %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("group context not cancelled")
+	}
+	parent.Finish()
+
+	p := parent.(*mocktracer.MockSpan)
+	if p.Tag(TagFailed) != "checkToken" || len(p.Logs()) != 1 {
+		t.Fatalf("failed branch not recorded: %v %v", p.Tags(), p.Logs())
+	}
+}
+
+func TestWithoutParentSpan(t *testing.T) {
+	g, _ := WithContext(context.Background())
+	g.Go("work", func(ctx context.Context) error { return nil })
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
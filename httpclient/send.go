@@ -0,0 +1,65 @@
+package httpclient
+
+import (
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"io"
+	"io/ioutil"
+	"net/http"
+	. "opentracing-sample/config"
+	"strconv"
+	"time"
+)
+
+// Result 是一次 Send 的结果，用 TraceID 到 jaeger 中查看，用 RequestID 在服务端日志中查找
+type Result struct {
+	Latency   time.Duration `json:"latency"`
+	Status    int           `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	TraceID   string        `json:"trace_id"`
+	RequestID string        `json:"request_id"`
+}
+
+// Failed 表示请求出错或响应状态码不小于 400
+func (r Result) Failed() bool {
+	return r.Error != "" || r.Status >= http.StatusBadRequest
+}
+
+// Outcome 是报告中显示的结果：出错时为错误信息，否则为状态码
+func (r Result) Outcome() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return strconv.Itoa(r.Status)
+}
+
+// Send 在 operation 的根 span 下发出 req 并读完响应体，供 loadgen、replay 这类自己发起请求的工具使用。
+// client 应由 NewClient 创建，由它创建客户端 span 并注入传播头；根 span 不标记为客户端，
+// 否则依赖图会把它当作没有对端的调用。请求带上新生成的 x-request-id，tags 设置在根 span 上
+func Send(client *http.Client, tracer opentracing.Tracer, operation string, req *http.Request, tags opentracing.Tags) Result {
+	span := tracer.StartSpan(operation, tags)
+	defer span.Finish()
+	res := Result{TraceID: TraceID(span), RequestID: uuid.New().String()}
+	span.SetTag("x-request-id", res.RequestID)
+
+	req = req.WithContext(opentracing.ContextWithSpan(req.Context(), span))
+	req.Header.Set("x-request-id", res.RequestID)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		res.Status = resp.StatusCode
+	}
+	res.Latency = time.Since(start)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if res.Failed() {
+		ext.Error.Set(span, true)
+	}
+	ext.HTTPStatusCode.Set(span, uint16(res.Status))
+	return res
+}
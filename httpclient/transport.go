@@ -0,0 +1,291 @@
+// Package httpclient 提供带追踪的 http.RoundTripper，出站请求会创建客户端 span 并注入传播头
+package httpclient
+
+import (
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/uber/jaeger-client-go"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"opentracing-sample/breaker"
+	. "opentracing-sample/config"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const component = "net/http"
+
+// Propagator 把 span 上下文写入出站请求头
+type Propagator interface {
+	Inject(tracer opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error
+}
+
+// TracerPropagator 使用 tracer 自身的 HTTPHeaders 格式（jaeger 为 uber-trace-id）
+type TracerPropagator struct{}
+
+func (TracerPropagator) Inject(tracer opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error {
+	return tracer.Inject(sc, opentracing.HTTPHeaders, &BaggageWriter{TextMapWriter: opentracing.HTTPHeadersCarrier(h)})
+}
+
+// B3Propagator 写入 istio/envoy 使用的 zipkin B3 头，与 TracerWrapper 中的提取对应
+type B3Propagator struct{}
+
+func (B3Propagator) Inject(_ opentracing.Tracer, sc opentracing.SpanContext, h http.Header) error {
+	jsc, ok := sc.(jaeger.SpanContext)
+	if !ok {
+		return nil
+	}
+	return ZipkinPropagator.Inject(jsc, &BaggageWriter{TextMapWriter: opentracing.HTTPHeadersCarrier(h)})
+}
+
+// DefaultPropagators 同时写 jaeger 和 B3 头，保证经过 envoy 的调用也能串起来
+var DefaultPropagators = []Propagator{TracerPropagator{}, B3Propagator{}}
+
+type options struct {
+	tracer      func() opentracing.Tracer
+	propagators []Propagator
+	maxRetries  int
+	backoff     time.Duration
+	breakers    *breaker.Registry
+}
+
+// Option 配置 Transport
+type Option func(*options)
+
+// WithTracer 指定 tracer，默认使用 opentracing.GlobalTracer()
+func WithTracer(tracer opentracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = func() opentracing.Tracer { return tracer }
+	}
+}
+
+// WithPropagators 替换默认的传播格式
+func WithPropagators(propagators ...Propagator) Option {
+	return func(o *options) {
+		o.propagators = propagators
+	}
+}
+
+// WithRetries 对幂等请求在网络错误或 502/503/504 时最多重试 n 次
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = n
+		o.backoff = backoff
+	}
+}
+
+// WithBreakers 为每个目标主机使用 r 中名为 http:<host> 的熔断器，网络错误和 5xx 计为失败
+func WithBreakers(r *breaker.Registry) Option {
+	return func(o *options) {
+		o.breakers = r
+	}
+}
+
+// Transport 是带追踪的 http.RoundTripper
+type Transport struct {
+	base http.RoundTripper
+	opts *options
+}
+
+// NewTransport 包装 base，base 为 nil 时使用 http.DefaultTransport
+func NewTransport(base http.RoundTripper, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	o := &options{tracer: opentracing.GlobalTracer, propagators: DefaultPropagators}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Transport{base: base, opts: o}
+}
+
+// NewClient 返回使用追踪 Transport 的 http.Client
+func NewClient(opts ...Option) *http.Client {
+	return &http.Client{Transport: NewTransport(nil, opts...)}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tracer := t.opts.tracer()
+	spanOpts := []opentracing.StartSpanOption{
+		opentracing.Tag{Key: string(ext.Component), Value: component},
+		ext.SpanKindRPCClient,
+	}
+	if parent := opentracing.SpanFromContext(req.Context()); parent != nil {
+		spanOpts = append(spanOpts, opentracing.ChildOf(parent.Context()))
+	}
+	span := tracer.StartSpan("HTTP "+req.Method, spanOpts...)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, redactURL(req))
+	tagPeer(span, req)
+
+	// http.Client 跟随重定向时会把上一跳的响应放在 req.Response 里
+	if prev := req.Response; prev != nil {
+		span.LogFields(
+			log.String("event", "redirect"),
+			log.Int("redirect.status", prev.StatusCode),
+			log.String("redirect.from", redactURL(prev.Request)),
+		)
+	}
+
+	resp, err := t.guardedRoundTrip(tracer, span, req)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "error"), log.Error(err))
+		span.Finish()
+		return nil, err
+	}
+
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+	if resp.Body == nil || resp.Body == http.NoBody || req.Method == http.MethodHead {
+		span.Finish()
+		return resp, nil
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// guardedRoundTrip 在配置了熔断器时先检查目标主机的熔断器，拒绝的请求记录在 span 上
+func (t *Transport) guardedRoundTrip(tracer opentracing.Tracer, span opentracing.Span, req *http.Request) (*http.Response, error) {
+	if t.opts.breakers == nil {
+		return t.roundTrip(tracer, span, req)
+	}
+	done, err := t.opts.breakers.Get("http:" + req.URL.Host).Allow(opentracing.ContextWithSpan(req.Context(), span))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(tracer, span, req)
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		done(errors.New(resp.Status))
+	} else {
+		done(err)
+	}
+	return resp, err
+}
+
+func (t *Transport) roundTrip(tracer opentracing.Tracer, span opentracing.Span, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		outReq := req.Clone(req.Context())
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			outReq.Body = body
+		}
+		for _, p := range t.opts.propagators {
+			if err := p.Inject(tracer, span.Context(), outReq.Header); err != nil {
+				span.LogFields(log.String("event", "inject error"), log.Error(err))
+			}
+		}
+
+		resp, err := t.base.RoundTrip(outReq)
+		if attempt >= t.opts.maxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		span.LogFields(log.String("event", "retry"), log.Int("attempt", attempt+1), log.String("reason", reason))
+
+		timer := time.NewTimer(t.opts.backoff << uint(attempt))
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// redactURL 去掉 userinfo 和 query，避免凭证进入 span
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+func tagPeer(span opentracing.Span, req *http.Request) {
+	host, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host = req.URL.Hostname()
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		ext.PeerHostIPv4.SetString(span, host)
+	} else {
+		ext.PeerHostname.Set(span, host)
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		ext.PeerPort.Set(span, uint16(p))
+	}
+}
+
+// spanBody 在响应体读到 EOF 或被关闭时结束 span，使 span 覆盖读取 body 的时间
+type spanBody struct {
+	io.ReadCloser
+	span  opentracing.Span
+	once  sync.Once
+	bytes int
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += n
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *spanBody) finish(err error) {
+	b.once.Do(func() {
+		if err != nil {
+			ext.Error.Set(b.span, true)
+			b.span.LogFields(log.String("event", "body read error"), log.Error(err))
+		}
+		b.span.SetTag("http.response_bytes", b.bytes)
+		b.span.Finish()
+	})
+}
@@ -0,0 +1,177 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/jaeger-client-go"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"opentracing-sample/breaker"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPropagatesHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+
+	parent := tracer.StartSpan("parent")
+	req, _ := http.NewRequestWithContext(opentracing.ContextWithSpan(context.Background(), parent), "GET", srv.URL+"/x?token=secret", nil)
+	resp, err := NewClient(WithTracer(tracer)).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reporter.SpansSubmitted() != 0 {
+		t.Fatal("span finished before body was closed")
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	spans := reporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0].(*jaeger.Span)
+	sc := span.SpanContext()
+	if got.Get("Uber-Trace-Id") != sc.String() {
+		t.Errorf("uber-trace-id = %q, want %q", got.Get("Uber-Trace-Id"), sc.String())
+	}
+	if got.Get("X-B3-Traceid") != sc.TraceID().String() || got.Get("X-B3-Spanid") != sc.SpanID().String() {
+		t.Errorf("missing B3 headers: %v", got)
+	}
+	if sc.ParentID() != parent.Context().(jaeger.SpanContext).SpanID() {
+		t.Error("span is not a child of the request span")
+	}
+	tags := span.Tags()
+	if tags["http.url"] != srv.URL+"/x" || tags["http.status_code"] != uint16(200) || tags["http.response_bytes"] != 2 {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+	if req.Header.Get("Uber-Trace-Id") != "" {
+		t.Error("original request was modified")
+	}
+}
+
+func TestRetriesAndRedirects(t *testing.T) {
+	var calls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tracer := mocktracer.New()
+	resp, err := NewClient(WithTracer(tracer), WithRetries(3, time.Millisecond)).Get(srv.URL + "/flaky?token=s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected a span per hop, got %d", len(spans))
+	}
+	first, second := spans[0], spans[1]
+	if len(first.Logs()) != 2 || first.Logs()[0].Fields[0].ValueString != "retry" {
+		t.Errorf("expected two retry logs, got %v", first.Logs())
+	}
+	if first.Tag("http.status_code") != uint16(http.StatusFound) {
+		t.Errorf("first hop status: %v", first.Tags())
+	}
+	if second.Logs()[0].Fields[0].ValueString != "redirect" || second.Logs()[0].Fields[2].ValueString != srv.URL+"/flaky" {
+		t.Errorf("expected redirect log without the query, got %v", second.Logs())
+	}
+	if second.Tag("error") != true {
+		t.Errorf("5xx should mark span as error: %v", second.Tags())
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	tracer := mocktracer.New()
+	_, err := NewClient(WithTracer(tracer)).Get("http://127.0.0.1:1/")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 || spans[0].Tag("error") != true {
+		t.Fatalf("expected one error span, got %v", spans)
+	}
+}
+
+func TestBreakers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	registry := breaker.NewRegistry(breaker.Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	tracer := mocktracer.New()
+	client := NewClient(WithTracer(tracer), WithBreakers(registry))
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err := client.Get(srv.URL); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("expected breaker error, got %v", err)
+	}
+	spans := tracer.FinishedSpans()
+	if len(spans) != 2 || spans[1].Tag("error") != true || len(spans[1].Logs()) == 0 {
+		t.Fatalf("rejected request not recorded: %v", spans)
+	}
+	if s := registry.Snapshot(); len(s) != 1 || s[0].State != "open" {
+		t.Fatalf("unexpected breakers %+v", s)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var requestID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("x-request-id")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("loadgen", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/api/product/1", nil)
+	res := Send(NewClient(WithTracer(tracer)), tracer, "loadgen GET /api/product/1", req, opentracing.Tags{"run": "smoke"})
+	if !res.Failed() || res.Outcome() != "500" || res.RequestID == "" || res.RequestID != requestID || res.TraceID == "" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	spans := reporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected client and root spans, got %d", len(spans))
+	}
+	client, root := spans[0].(*jaeger.Span), spans[1].(*jaeger.Span)
+	if client.SpanContext().ParentID() != root.SpanContext().SpanID() || root.SpanContext().TraceID().String() != res.TraceID {
+		t.Fatal("client span is not a child of the root span")
+	}
+	tags := root.Tags()
+	if tags["span.kind"] != nil || tags["error"] != true || tags["run"] != "smoke" || tags["x-request-id"] != res.RequestID {
+		t.Errorf("unexpected root span tags: %v", tags)
+	}
+}
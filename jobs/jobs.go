@@ -0,0 +1,261 @@
+// Package jobs 是进程内的后台任务队列：处理函数把任务放进有界队列后立即返回，
+// 固定数量的 worker 在响应发出之后执行任务。任务的 span 通过 FollowsFrom 关联到发起请求的 span，
+// 并带上请求的 baggage；每次尝试是独立的子 span，重试用尽的任务进入死信列表。
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"net/http"
+	"opentracing-sample/config"
+	"opentracing-sample/recovery"
+	"sync"
+	"time"
+)
+
+// span 标签
+const (
+	TagName     = "job.name"
+	TagAttempt  = "job.attempt"
+	TagAttempts = "job.attempts"
+	TagOutcome  = "job.outcome"
+	TagQueued   = "job.queued_ms"
+)
+
+// 记录在任务 span 上的最终结果
+const (
+	OutcomeSuccess    = "success"
+	OutcomeDeadLetter = "dead_letter"
+)
+
+var (
+	// ErrQueueFull 表示队列已满，任务没有入队
+	ErrQueueFull = errors.New("jobs: queue full")
+	// ErrClosed 表示队列已经关闭
+	ErrClosed = errors.New("jobs: queue closed")
+)
+
+// Stats 按 enqueued、rejected、succeeded、retried、dead_lettered 统计任务数，通过 /debug/vars 暴露
+var Stats = expvar.NewMap("jobs")
+
+// Config 是队列的配置。Backoff 是第一次重试前的等待时间，之后每次翻倍
+type Config struct {
+	Workers        int
+	QueueSize      int
+	MaxAttempts    int
+	Backoff        time.Duration
+	Timeout        time.Duration
+	DeadLetterSize int
+}
+
+// DefaultConfig 使用 4 个 worker，每个任务最多尝试 3 次
+var DefaultConfig = Config{
+	Workers:        4,
+	QueueSize:      100,
+	MaxAttempts:    3,
+	Backoff:        100 * time.Millisecond,
+	Timeout:        5 * time.Second,
+	DeadLetterSize: 100,
+}
+
+// DeadLetter 是重试用尽的任务，TraceID 用于在 jaeger 中找到对应的任务 span
+type DeadLetter struct {
+	Job      string    `json:"job"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	TraceID  string    `json:"trace_id"`
+	Failed   time.Time `json:"failed"`
+}
+
+type task struct {
+	name     string
+	fn       func(ctx context.Context) error
+	tracer   opentracing.Tracer
+	ref      opentracing.SpanContext
+	baggage  map[string]string
+	enqueued time.Time
+}
+
+// Queue 是有界的任务队列和 worker 池，需要通过 New 创建
+type Queue struct {
+	cfg   Config
+	tasks chan *task
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	dead   []DeadLetter
+}
+
+// New 创建队列并启动 worker
+func New(cfg Config) *Queue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 1
+	}
+	q := &Queue{cfg: cfg, tasks: make(chan *task, cfg.QueueSize)}
+	for i := 0; i < cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue 把名为 name 的任务放进队列，不会阻塞。任务在新的上下文中执行，不受请求取消的影响，
+// 只继承 ctx 中 span 的引用和 baggage
+func (q *Queue) Enqueue(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	t := &task{name: name, fn: fn, tracer: opentracing.GlobalTracer(), enqueued: time.Now()}
+	span := opentracing.SpanFromContext(ctx)
+	if span != nil {
+		t.tracer = span.Tracer()
+		t.ref = span.Context()
+		t.baggage = map[string]string{}
+		span.Context().ForeachBaggageItem(func(k, v string) bool {
+			t.baggage[k] = v
+			return true
+		})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := ErrClosed
+	if !q.closed {
+		select {
+		case q.tasks <- t:
+			err = nil
+		default:
+			err = ErrQueueFull
+		}
+	}
+	if err != nil {
+		Stats.Add("rejected", 1)
+	} else {
+		Stats.Add("enqueued", 1)
+	}
+	if span != nil {
+		fields := []log.Field{log.String("event", "job.enqueued"), log.String(TagName, name)}
+		if err != nil {
+			fields = append(fields, log.Error(err))
+		}
+		span.LogFields(fields...)
+	}
+	return err
+}
+
+// Close 停止接收新任务，等待已入队的任务执行完
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.tasks)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// DeadLetters 返回死信列表的副本，最早的在前
+func (q *Queue) DeadLetters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter{}, q.dead...)
+}
+
+// ServeHTTP 以 JSON 输出死信列表，用作调试端点
+func (q *Queue) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q.DeadLetters())
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for t := range q.tasks {
+		q.run(t)
+	}
+}
+
+// run 在 FollowsFrom 请求 span 的任务 span 中执行任务，失败时按指数退避重试
+func (q *Queue) run(t *task) {
+	opts := []opentracing.StartSpanOption{
+		opentracing.Tag{Key: TagName, Value: t.name},
+		opentracing.Tag{Key: TagQueued, Value: time.Since(t.enqueued).Milliseconds()},
+	}
+	if t.ref != nil {
+		opts = append(opts, opentracing.FollowsFrom(t.ref))
+	}
+	span := t.tracer.StartSpan("job "+t.name, opts...)
+	defer span.Finish()
+	for k, v := range t.baggage {
+		span.SetBaggageItem(k, v)
+	}
+	config.TagBaggage(span)
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		if err = q.attempt(t, span, attempt); err == nil {
+			break
+		}
+		if attempt == q.cfg.MaxAttempts {
+			break
+		}
+		backoff := q.cfg.Backoff << uint(attempt-1)
+		span.LogFields(log.String("event", "retry"), log.Int(TagAttempt, attempt),
+			log.Error(err), log.String("backoff", backoff.String()))
+		Stats.Add("retried", 1)
+		time.Sleep(backoff)
+	}
+	span.SetTag(TagAttempts, attempt)
+	if err == nil {
+		span.SetTag(TagOutcome, OutcomeSuccess)
+		Stats.Add("succeeded", 1)
+		return
+	}
+
+	span.SetTag(TagOutcome, OutcomeDeadLetter)
+	ext.Error.Set(span, true)
+	span.SetTag("error.message", err.Error())
+	Stats.Add("dead_lettered", 1)
+	d := DeadLetter{Job: t.name, Error: err.Error(), Attempts: attempt, TraceID: config.TraceID(span), Failed: time.Now()}
+	config.Log.WithField("component", "jobs").WithField("trace_id", d.TraceID).
+		Errorf("job %s dead-lettered after %d attempts: %v", t.name, attempt, err)
+
+	q.mu.Lock()
+	q.dead = append(q.dead, d)
+	if q.cfg.DeadLetterSize > 0 && len(q.dead) > q.cfg.DeadLetterSize {
+		q.dead = q.dead[len(q.dead)-q.cfg.DeadLetterSize:]
+	}
+	q.mu.Unlock()
+}
+
+// attempt 在任务 span 的子 span 中执行一次任务，panic 记录后按失败处理
+func (q *Queue) attempt(t *task, parent opentracing.Span, n int) (err error) {
+	span := t.tracer.StartSpan(t.name, opentracing.ChildOf(parent.Context()),
+		opentracing.Tag{Key: TagAttempt, Value: n})
+	defer span.Finish()
+
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	if q.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.cfg.Timeout)
+		defer cancel()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			recovery.Record(ctx, "jobs", p)
+			err = fmt.Errorf("jobs: panic: %v", p)
+		}
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.SetTag("error.message", err.Error())
+		}
+	}()
+	return t.fn(ctx)
+}
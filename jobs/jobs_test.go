@@ -0,0 +1,130 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"net/http/httptest"
+	"opentracing-sample/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func spansByName(reporter *jaeger.InMemoryReporter) map[string][]*jaeger.Span {
+	spans := map[string][]*jaeger.Span{}
+	for _, s := range reporter.GetSpans() {
+		span := s.(*jaeger.Span)
+		spans[span.OperationName()] = append(spans[span.OperationName()], span)
+	}
+	return spans
+}
+
+func TestFollowsFromAndBaggage(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+
+	request := tracer.StartSpan("POST /api/product/:id/reviews")
+	request.SetBaggageItem(config.BaggageTenant, "acme")
+	ctx := opentracing.ContextWithSpan(context.Background(), request)
+
+	q := New(Config{Workers: 1, QueueSize: 1, MaxAttempts: 1})
+	var tenant string
+	err := q.Enqueue(ctx, "recomputeRating", func(ctx context.Context) error {
+		tenant = config.GetBaggage(ctx, config.BaggageTenant)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Finish()
+	q.Close()
+
+	spans := spansByName(reporter)
+	job, attempt := spans["job recomputeRating"], spans["recomputeRating"]
+	if len(job) != 1 || len(attempt) != 1 {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	refs := job[0].References()
+	if len(refs) != 1 || refs[0].Type != opentracing.FollowsFromRef ||
+		refs[0].ReferencedContext.(jaeger.SpanContext).SpanID() != request.Context().(jaeger.SpanContext).SpanID() {
+		t.Fatalf("job span does not follow from the request span: %v", refs)
+	}
+	if attempt[0].SpanContext().ParentID() != job[0].SpanContext().SpanID() || attempt[0].Tags()[TagAttempt] != 1 {
+		t.Fatalf("attempt span not nested under the job span: %v", attempt[0].Tags())
+	}
+	if tenant != "acme" {
+		t.Fatalf("baggage not carried across: tenant=%q", tenant)
+	}
+	if job[0].Tags()[TagOutcome] != OutcomeSuccess || job[0].Tags()["baggage.tenant"] != "acme" {
+		t.Fatalf("unexpected tags: %v", job[0].Tags())
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("request"))
+
+	q := New(Config{Workers: 2, QueueSize: 2, MaxAttempts: 3, Backoff: time.Millisecond})
+	var flaky int32
+	q.Enqueue(ctx, "flaky", func(context.Context) error {
+		if atomic.AddInt32(&flaky, 1) < 2 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	q.Enqueue(ctx, "broken", func(context.Context) error { panic("boom") })
+	q.Close()
+
+	spans := spansByName(reporter)
+	if len(spans["flaky"]) != 2 || spans["job flaky"][0].Tags()[TagAttempts] != 2 ||
+		spans["job flaky"][0].Tags()[TagOutcome] != OutcomeSuccess {
+		t.Fatalf("flaky job not retried once: %v", spans)
+	}
+	broken := spans["job broken"][0]
+	if len(spans["broken"]) != 3 || broken.Tags()[TagOutcome] != OutcomeDeadLetter || broken.Tags()["error"] != true {
+		t.Fatalf("broken job not dead-lettered: %v", broken.Tags())
+	}
+	if len(broken.Logs()) != 2 {
+		t.Fatalf("expected a retry log per backoff, got %v", broken.Logs())
+	}
+
+	rec := httptest.NewRecorder()
+	q.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/jobs", nil))
+	var dead []DeadLetter
+	if err := json.NewDecoder(rec.Body).Decode(&dead); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Job != "broken" || dead[0].Attempts != 3 ||
+		dead[0].TraceID != broken.SpanContext().TraceID().String() {
+		t.Fatalf("unexpected dead letters %+v", dead)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := New(Config{Workers: 1, QueueSize: 1})
+	block := make(chan struct{})
+	started := make(chan struct{})
+	q.Enqueue(context.Background(), "block", func(context.Context) error {
+		close(started)
+		<-block
+		return nil
+	})
+	<-started
+	if err := q.Enqueue(context.Background(), "queued", func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(context.Background(), "rejected", func(context.Context) error { return nil }); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	close(block)
+	q.Close()
+	if err := q.Enqueue(context.Background(), "late", func(context.Context) error { return nil }); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package messaging
+
+import (
+	"context"
+	. "opentracing-sample/config"
+	"sync"
+)
+
+// Memory 是进程内的 broker，同时实现 Publisher 和 Subscriber。
+// 每个订阅有自己的缓冲队列和 goroutine，按发布顺序投递；处理失败只记录日志，不重新投递。
+type Memory struct {
+	buffer int
+
+	mu     sync.RWMutex
+	subs   map[string][]*subscription
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// subscription 的 queue 从不关闭，取消订阅时关闭 done，正在阻塞发送的 Publish 随之返回
+type subscription struct {
+	handler Handler
+	queue   chan *Message
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (s *subscription) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// NewMemory 创建 broker，buffer 是每个订阅的队列长度，队列满时 Publish 阻塞
+func NewMemory(buffer int) *Memory {
+	return &Memory{buffer: buffer, subs: map[string][]*subscription{}}
+}
+
+// Publish 把消息的副本投递给 m.Topic 的所有订阅，订阅方修改消息不会相互影响。
+// 等待队列时不持有锁，处理函数中取消订阅或关闭 broker 不会死锁；期间取消的订阅收不到这条消息
+func (b *Memory) Publish(ctx context.Context, m *Message) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+	subs := append([]*subscription(nil), b.subs[m.Topic]...)
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		select {
+		case s.queue <- copyMessage(m):
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe 为 topic 注册 h，h 在独立的 goroutine 中依次处理消息
+func (b *Memory) Subscribe(topic string, h Handler) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	s := &subscription{handler: h, queue: make(chan *Message, b.buffer), done: make(chan struct{})}
+	b.subs[topic] = append(b.subs[topic], s)
+	b.wg.Add(1)
+	go b.deliver(s)
+	return func() { b.unsubscribe(topic, s) }, nil
+}
+
+func (b *Memory) unsubscribe(topic string, s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.subs[topic]
+	for i, sub := range subs {
+		if sub == s {
+			b.subs[topic] = append(subs[:i:i], subs[i+1:]...)
+			s.close()
+			return
+		}
+	}
+}
+
+// Close 停止接收消息，等待所有已投递的消息处理完
+func (b *Memory) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, subs := range b.subs {
+			for _, s := range subs {
+				s.close()
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// deliver 依次处理 s 的消息，订阅取消后处理完队列中剩余的消息再退出
+func (b *Memory) deliver(s *subscription) {
+	defer b.wg.Done()
+	for {
+		select {
+		case m := <-s.queue:
+			b.handle(s, m)
+		case <-s.done:
+			for {
+				select {
+				case m := <-s.queue:
+					b.handle(s, m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *Memory) handle(s *subscription, m *Message) {
+	if err := s.handler(context.Background(), m); err != nil {
+		Log.WithField("component", component).Warnf("message %s on %s failed: %v", m.ID, m.Topic, err)
+	}
+}
+
+func copyMessage(m *Message) *Message {
+	c := *m
+	c.Headers = make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		c.Headers[k] = v
+	}
+	c.Body = append([]byte(nil), m.Body...)
+	return &c
+}
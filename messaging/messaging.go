@@ -0,0 +1,51 @@
+// Package messaging 定义消息的发布和订阅接口，并在消息头中传播 span 上下文：
+// 发布时创建 producer span 并注入，消费时提取后创建 consumer span。
+// 目前只有进程内的实现，Kafka、NATS 等适配器只需要实现 Publisher 和 Subscriber。
+package messaging
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed 表示 broker 已经关闭
+var ErrClosed = errors.New("messaging: closed")
+
+// Message 是一条消息，Headers 用于携带 span 上下文等元数据
+type Message struct {
+	ID      string
+	Topic   string
+	Headers map[string]string
+	Body    []byte
+}
+
+// Handler 处理一条消息，ctx 中带有 consumer span
+type Handler func(ctx context.Context, m *Message) error
+
+// Publisher 把消息发布到 m.Topic
+type Publisher interface {
+	Publish(ctx context.Context, m *Message) error
+}
+
+// Subscriber 为 topic 注册处理函数，返回的函数用于取消订阅
+type Subscriber interface {
+	Subscribe(topic string, h Handler) (unsubscribe func(), err error)
+}
+
+// HeaderCarrier 让消息头可以作为 opentracing.TextMap 载体
+type HeaderCarrier map[string]string
+
+// ForeachKey 实现 opentracing.TextMapReader
+func (c HeaderCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, v := range c {
+		if err := handler(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set 实现 opentracing.TextMapWriter
+func (c HeaderCarrier) Set(key, val string) {
+	c[key] = val
+}
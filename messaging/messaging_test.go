@@ -0,0 +1,164 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"opentracing-sample/config"
+	"testing"
+	"time"
+)
+
+func newTracer() (opentracing.Tracer, *jaeger.InMemoryReporter, func()) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(true), reporter)
+	return tracer, reporter, func() { closer.Close() }
+}
+
+func spansByName(reporter *jaeger.InMemoryReporter) map[string]*jaeger.Span {
+	spans := map[string]*jaeger.Span{}
+	for _, s := range reporter.GetSpans() {
+		spans[s.(*jaeger.Span).OperationName()] = s.(*jaeger.Span)
+	}
+	return spans
+}
+
+func TestPropagation(t *testing.T) {
+	tracer, reporter, closeTracer := newTracer()
+	defer closeTracer()
+	broker := NewMemory(1)
+	pub, sub := TracePublisher(broker, WithTracer(tracer)), TraceSubscriber(broker, WithTracer(tracer))
+
+	var tenant string
+	if _, err := sub.Subscribe("reviews", func(ctx context.Context, m *Message) error {
+		tenant = config.GetBaggage(ctx, config.BaggageTenant)
+		if opentracing.SpanFromContext(ctx).BaggageItem("secret") != "" {
+			t.Error("baggage outside the allow list reached the consumer")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := tracer.StartSpan("POST /api/product/:id/reviews")
+	parent.SetBaggageItem(config.BaggageTenant, "acme")
+	parent.SetBaggageItem("secret", "x")
+	m := &Message{Topic: "reviews", Headers: map[string]string{"content-type": "application/json"}, Body: []byte("{}")}
+	if err := pub.Publish(opentracing.ContextWithSpan(context.Background(), parent), m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Headers) != 1 {
+		t.Errorf("trace headers injected into the caller's message: %v", m.Headers)
+	}
+	parent.Finish()
+	broker.Close()
+
+	spans := spansByName(reporter)
+	producer, consumer := spans["publish reviews"], spans["consume reviews"]
+	if producer == nil || consumer == nil {
+		t.Fatalf("missing spans: %v", spans)
+	}
+	if producer.SpanContext().ParentID() != parent.Context().(jaeger.SpanContext).SpanID() {
+		t.Error("producer span is not a child of the request span")
+	}
+	refs := consumer.References()
+	if len(refs) != 1 || refs[0].Type != opentracing.FollowsFromRef ||
+		refs[0].ReferencedContext.(jaeger.SpanContext).SpanID() != producer.SpanContext().SpanID() {
+		t.Fatalf("consumer span does not follow from the producer span: %v", refs)
+	}
+	for span, kind := range map[*jaeger.Span]ext.SpanKindEnum{producer: ext.SpanKindProducerEnum, consumer: ext.SpanKindConsumerEnum} {
+		tags := span.Tags()
+		if tags["span.kind"] != kind || tags["message_bus.destination"] != "reviews" || tags[TagMessageID] != m.ID || m.ID == "" {
+			t.Errorf("unexpected tags on %s: %v", span.OperationName(), tags)
+		}
+	}
+	if tenant != "acme" {
+		t.Errorf("baggage not carried across: tenant=%q", tenant)
+	}
+}
+
+func TestConsumerError(t *testing.T) {
+	tracer, reporter, closeTracer := newTracer()
+	defer closeTracer()
+	broker := NewMemory(1)
+	TraceSubscriber(broker, WithTracer(tracer)).Subscribe("reviews", func(context.Context, *Message) error {
+		return errors.New("boom")
+	})
+
+	// 没有父 span 时 producer span 是新 trace 的根
+	if err := TracePublisher(broker, WithTracer(tracer)).Publish(context.Background(), &Message{Topic: "reviews"}); err != nil {
+		t.Fatal(err)
+	}
+	broker.Close()
+
+	spans := spansByName(reporter)
+	if spans["consume reviews"].Tags()["error"] != true || spans["publish reviews"].SpanContext().ParentID() != 0 {
+		t.Fatalf("unexpected spans: %v", spans)
+	}
+	if spans["consume reviews"].SpanContext().TraceID() != spans["publish reviews"].SpanContext().TraceID() {
+		t.Fatal("consumer span not in the producer's trace")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	broker := NewMemory(2)
+	got := make(chan *Message, 4)
+	unsubscribe, _ := broker.Subscribe("a", func(_ context.Context, m *Message) error {
+		m.Headers["k"] = "changed"
+		got <- m
+		return nil
+	})
+	broker.Subscribe("b", func(context.Context, *Message) error {
+		t.Error("message delivered to the wrong topic")
+		return nil
+	})
+
+	m := &Message{ID: "1", Topic: "a", Headers: map[string]string{"k": "v"}}
+	broker.Publish(context.Background(), m)
+	if r := <-got; r.ID != "1" || m.Headers["k"] != "v" {
+		t.Fatalf("subscriber did not get a copy: %+v %+v", r, m)
+	}
+	unsubscribe()
+	broker.Publish(context.Background(), m)
+
+	broker.Close()
+	if len(got) != 0 {
+		t.Fatal("message delivered after unsubscribe")
+	}
+	if err := broker.Publish(context.Background(), m); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if _, err := broker.Subscribe("a", nil); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMemoryUnsubscribeWhilePublishing(t *testing.T) {
+	broker := NewMemory(0)
+	defer broker.Close()
+	proceed := make(chan struct{})
+	var unsubscribe func()
+	unsubscribe, _ = broker.Subscribe("a", func(context.Context, *Message) error {
+		<-proceed
+		unsubscribe()
+		return nil
+	})
+
+	broker.Publish(context.Background(), &Message{ID: "1", Topic: "a"})
+	// 处理函数还在等待，第二条消息阻塞在没有缓冲的队列上；之后处理函数取消订阅
+	published := make(chan error)
+	go func() { published <- broker.Publish(context.Background(), &Message{ID: "2", Topic: "a"}) }()
+	time.Sleep(10 * time.Millisecond)
+	close(proceed)
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publish deadlocked with unsubscribe")
+	}
+}
@@ -0,0 +1,120 @@
+package messaging
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	. "opentracing-sample/config"
+)
+
+const component = "messaging"
+
+// TagMessageID 是 producer 和 consumer span 上的消息 ID 标签
+const TagMessageID = "message_bus.message_id"
+
+type options struct {
+	tracer func() opentracing.Tracer
+}
+
+// Option 配置 TracePublisher 和 TraceSubscriber
+type Option func(*options)
+
+// WithTracer 指定 tracer，默认使用 opentracing.GlobalTracer()
+func WithTracer(tracer opentracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = func() opentracing.Tracer { return tracer }
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{tracer: opentracing.GlobalTracer}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+type tracedPublisher struct {
+	Publisher
+	opts *options
+}
+
+// TracePublisher 包装 p：每次发布创建 producer span，并把 span 上下文注入消息副本的消息头，
+// 调用方的消息头不变，重发同一条消息时不会带着上一次的上下文。
+// 消息没有 ID 时生成一个并写回 m，保证 producer 和 consumer span 上的 ID 一致。
+func TracePublisher(p Publisher, opts ...Option) Publisher {
+	return &tracedPublisher{Publisher: p, opts: newOptions(opts)}
+}
+
+func (p *tracedPublisher) Publish(ctx context.Context, m *Message) error {
+	if m.ID == "" {
+		m.ID = uuid.New().String()
+	}
+	tracer := p.opts.tracer()
+	opts := []opentracing.StartSpanOption{ext.SpanKindProducer, opentracing.Tag{Key: TagMessageID, Value: m.ID}}
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		opts = append(opts, opentracing.ChildOf(parent.Context()))
+	}
+	span := tracer.StartSpan("publish "+m.Topic, opts...)
+	defer span.Finish()
+	ext.Component.Set(span, component)
+	ext.MessageBusDestination.Set(span, m.Topic)
+
+	out := *m
+	out.Headers = make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		out.Headers[k] = v
+	}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, &BaggageWriter{TextMapWriter: HeaderCarrier(out.Headers)}); err != nil {
+		Log.WithField("component", component).Warnf("could not inject span context: %v", err)
+	}
+	err := p.Publisher.Publish(opentracing.ContextWithSpan(ctx, span), &out)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	return err
+}
+
+type tracedSubscriber struct {
+	Subscriber
+	opts *options
+}
+
+// TraceSubscriber 包装 s：每条消息在从消息头提取的上下文之后创建 consumer span，
+// 两者通过 FollowsFrom 关联，因为发布方不等待消费结果
+func TraceSubscriber(s Subscriber, opts ...Option) Subscriber {
+	return &tracedSubscriber{Subscriber: s, opts: newOptions(opts)}
+}
+
+func (s *tracedSubscriber) Subscribe(topic string, h Handler) (func(), error) {
+	return s.Subscriber.Subscribe(topic, traceHandler(h, s.opts))
+}
+
+// traceHandler 为 h 处理的每条消息创建 consumer span
+func traceHandler(h Handler, o *options) Handler {
+	return func(ctx context.Context, m *Message) error {
+		tracer := o.tracer()
+		sc, err := tracer.Extract(opentracing.TextMap, BaggageReader{TextMapReader: HeaderCarrier(m.Headers)})
+		if err != nil && err != opentracing.ErrSpanContextNotFound {
+			Log.WithField("component", component).Warnf("could not extract span context: %v", err)
+		}
+		opts := []opentracing.StartSpanOption{ext.SpanKindConsumer, opentracing.Tag{Key: TagMessageID, Value: m.ID}}
+		if sc != nil {
+			opts = append(opts, opentracing.FollowsFrom(sc))
+		}
+		span := tracer.StartSpan("consume "+m.Topic, opts...)
+		defer span.Finish()
+		ext.Component.Set(span, component)
+		ext.MessageBusDestination.Set(span, m.Topic)
+		TagBaggage(span)
+
+		err = h(opentracing.ContextWithSpan(ctx, span), m)
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.SetTag("error.message", err.Error())
+		}
+		return err
+	}
+}
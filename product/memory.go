@@ -0,0 +1,142 @@
+package product
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepository 是进程内的仓储实现
+type MemoryRepository struct {
+	mu       sync.RWMutex
+	products map[int64]Product
+	reviews  map[int64][]Review
+	nextID   int64
+	reviewID int64
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		products: make(map[int64]Product),
+		reviews:  make(map[int64][]Review),
+	}
+}
+
+func (m *MemoryRepository) List(_ context.Context, f Filter) (Page, error) {
+	f = f.normalize()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := make([]Product, 0, len(m.products))
+	for _, p := range m.products {
+		if f.match(&p) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	page := Page{Items: []Product{}, Total: len(matched), Offset: f.Offset, Limit: f.Limit}
+	if f.Offset < len(matched) {
+		end := f.Offset + f.Limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Items = matched[f.Offset:end]
+	}
+	return page, nil
+}
+
+func (m *MemoryRepository) Get(_ context.Context, id int64) (*Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.products[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (m *MemoryRepository) Create(_ context.Context, p *Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.ID == 0 {
+		m.nextID++
+		p.ID = m.nextID
+	} else if p.ID > m.nextID {
+		m.nextID = p.ID
+	}
+	// 评分只能由 RecomputeRating 修改，和 SQL 实现保持一致
+	stored := *p
+	stored.Rating = 0
+	m.products[p.ID] = stored
+	return nil
+}
+
+func (m *MemoryRepository) Update(_ context.Context, p *Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.products[p.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored := *p
+	stored.Rating = old.Rating
+	m.products[p.ID] = stored
+	return nil
+}
+
+func (m *MemoryRepository) Delete(_ context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.products[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.products, id)
+	delete(m.reviews, id)
+	return nil
+}
+
+func (m *MemoryRepository) ListReviews(_ context.Context, productID int64) ([]Review, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.products[productID]; !ok {
+		return nil, ErrNotFound
+	}
+	return append([]Review{}, m.reviews[productID]...), nil
+}
+
+func (m *MemoryRepository) AddReview(_ context.Context, r *Review) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.products[r.ProductID]; !ok {
+		return ErrNotFound
+	}
+	m.reviewID++
+	r.ID = m.reviewID
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now().UTC()
+	}
+	m.reviews[r.ProductID] = append(m.reviews[r.ProductID], *r)
+	return nil
+}
+
+func (m *MemoryRepository) RecomputeRating(_ context.Context, id int64) (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.products[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	p.Rating = 0
+	if reviews := m.reviews[id]; len(reviews) > 0 {
+		sum := 0
+		for _, r := range reviews {
+			sum += r.Rating
+		}
+		p.Rating = math.Round(float64(sum)/float64(len(reviews))*100) / 100
+	}
+	m.products[id] = p
+	return p.Rating, nil
+}
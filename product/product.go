@@ -0,0 +1,120 @@
+// Package product 是商品目录的领域模型、仓储和服务层
+package product
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound 表示商品或评论不存在
+	ErrNotFound = errors.New("product: not found")
+	// ErrInvalid 表示参数校验失败，具体原因用 %w 包装在错误信息里
+	ErrInvalid = errors.New("product: invalid")
+)
+
+type Product struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	// Rating 是评论的平均分，由后台任务在新增评论后重新计算
+	Rating float64 `json:"rating"`
+}
+
+// Validate 检查创建和更新时必填的字段
+func (p *Product) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if p.Price < 0 {
+		return fmt.Errorf("%w: price must not be negative", ErrInvalid)
+	}
+	return nil
+}
+
+type Review struct {
+	ID        int64     `json:"id"`
+	ProductID int64     `json:"product_id"`
+	Author    string    `json:"author"`
+	Rating    int       `json:"rating"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate 检查评论的作者和评分
+func (r *Review) Validate() error {
+	if strings.TrimSpace(r.Author) == "" {
+		return fmt.Errorf("%w: author is required", ErrInvalid)
+	}
+	if r.Rating < 1 || r.Rating > 5 {
+		return fmt.Errorf("%w: rating must be between 1 and 5", ErrInvalid)
+	}
+	return nil
+}
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Filter 是列表查询的过滤和分页条件，零值表示不过滤
+type Filter struct {
+	Name     string
+	MinPrice float64
+	MaxPrice float64
+	Offset   int
+	Limit    int
+}
+
+func (f Filter) normalize() Filter {
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+	return f
+}
+
+func (f Filter) match(p *Product) bool {
+	if f.Name != "" && !strings.Contains(strings.ToLower(p.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	if f.MinPrice > 0 && p.Price < f.MinPrice {
+		return false
+	}
+	if f.MaxPrice > 0 && p.Price > f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// Page 是一页列表结果，Total 是过滤后的总数
+type Page struct {
+	Items  []Product `json:"items"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+}
+
+// Repository 是商品和评论的存储接口
+type Repository interface {
+	List(ctx context.Context, f Filter) (Page, error)
+	Get(ctx context.Context, id int64) (*Product, error)
+	Create(ctx context.Context, p *Product) error
+	Update(ctx context.Context, p *Product) error
+	Delete(ctx context.Context, id int64) error
+
+	ListReviews(ctx context.Context, productID int64) ([]Review, error)
+	AddReview(ctx context.Context, r *Review) error
+	// RecomputeRating 按所有评论重新计算并保存商品的平均分，保留两位小数，没有评论时为 0。
+	// 读取评论和写入评分必须是原子的，并发的重新计算不能用旧的平均分覆盖新的
+	RecomputeRating(ctx context.Context, id int64) (float64, error)
+}
@@ -0,0 +1,183 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	_ "modernc.org/sqlite"
+	"opentracing-sample/cache"
+	"testing"
+)
+
+func testRepository(t *testing.T, repo Repository) {
+	ctx := context.Background()
+	for _, p := range []*Product{
+		{Name: "Go in Action", Price: 30},
+		{Name: "Mastering Go", Price: 45},
+		{Name: "OpenTracing Handbook", Price: 60},
+	} {
+		if err := repo.Create(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+		if p.ID == 0 {
+			t.Fatal("id not assigned")
+		}
+	}
+
+	page, err := repo.List(ctx, Filter{Name: "go", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 1 || page.Items[0].Name != "Mastering Go" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+	// 名称中的 LIKE 通配符按字面匹配
+	for _, name := range []string{"%", "_", "o_n", "!"} {
+		if page, _ := repo.List(ctx, Filter{Name: name}); page.Total != 0 {
+			t.Fatalf("name %q matched %+v", name, page)
+		}
+	}
+	page, _ = repo.List(ctx, Filter{MinPrice: 40, MaxPrice: 50})
+	if page.Total != 1 || page.Items[0].Price != 45 {
+		t.Fatalf("unexpected price filter result: %+v", page)
+	}
+
+	p, err := repo.Get(ctx, 1)
+	if err != nil || p.Name != "Go in Action" {
+		t.Fatalf("get: %+v, %v", p, err)
+	}
+	p.Price = 35
+	if err := repo.Update(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Update(ctx, p); err != nil {
+		t.Fatalf("update without changes: %v", err)
+	}
+	if err := repo.Update(ctx, &Product{ID: 99, Name: "x"}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := repo.AddReview(ctx, &Review{ProductID: 1, Author: "alice", Rating: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddReview(ctx, &Review{ProductID: 99, Author: "bob", Rating: 1}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	reviews, err := repo.ListReviews(ctx, 1)
+	if err != nil || len(reviews) != 1 || reviews[0].Author != "alice" {
+		t.Fatalf("reviews: %+v, %v", reviews, err)
+	}
+
+	repo.AddReview(ctx, &Review{ProductID: 1, Author: "bob", Rating: 4})
+	repo.AddReview(ctx, &Review{ProductID: 1, Author: "carol", Rating: 4})
+	if rating, err := repo.RecomputeRating(ctx, 1); err != nil || rating != 4.33 {
+		t.Fatalf("rating = %v, %v", rating, err)
+	}
+	p.Price = 40
+	repo.Update(ctx, p)
+	if p, _ := repo.Get(ctx, 1); p.Rating != 4.33 || p.Price != 40 {
+		t.Fatalf("rating not kept across update: %+v", p)
+	}
+	if _, err := repo.RecomputeRating(ctx, 99); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := repo.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, 1); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := repo.Delete(ctx, 1); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMemoryRepository(t *testing.T) {
+	testRepository(t, NewMemoryRepository())
+}
+
+func TestSQLRepository(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := Migrate(context.Background(), db, "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+	testRepository(t, NewSQLRepository(db))
+}
+
+func TestServiceSpansAndCache(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	repo := NewMemoryRepository()
+	svc := NewService(repo, cache.NewMemory())
+	parent := tracer.StartSpan("GET /api/product/:id")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	if err := svc.Create(ctx, &Product{Name: ""}); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %v", err)
+	}
+	p := &Product{Name: "Go", Price: 1}
+	if err := svc.Create(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.Reset()
+	svc.Get(ctx, p.ID)
+	svc.Get(ctx, p.ID)
+
+	var repoGets, serviceGets int
+	for _, s := range tracer.FinishedSpans() {
+		switch s.OperationName {
+		case "ProductRepository.Get":
+			repoGets++
+		case "ProductService.Get":
+			serviceGets++
+			if s.ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+				t.Error("service span is not a child of the handler span")
+			}
+		}
+	}
+	if serviceGets != 2 || repoGets != 1 {
+		t.Fatalf("expected second Get to be served from cache, got %d service / %d repository spans", serviceGets, repoGets)
+	}
+
+	p.Name = "Go 2"
+	if err := svc.Update(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := svc.Get(ctx, p.ID)
+	if got.Name != "Go 2" {
+		t.Fatalf("cache not invalidated on update: %+v", got)
+	}
+}
+
+func TestRecomputeRating(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(NewMemoryRepository(), cache.NewMemory())
+	p := &Product{Name: "Go", Price: 1}
+	svc.Create(ctx, p)
+	svc.Get(ctx, p.ID)
+
+	for _, rating := range []int{5, 4, 4} {
+		svc.AddReview(ctx, &Review{ProductID: p.ID, Author: "alice", Rating: rating})
+	}
+	rating, err := svc.RecomputeRating(ctx, p.ID)
+	if err != nil || rating != 4.33 {
+		t.Fatalf("rating = %v, %v", rating, err)
+	}
+	if got, _ := svc.Get(ctx, p.ID); got.Rating != 4.33 {
+		t.Fatalf("cache not invalidated after recompute: %+v", got)
+	}
+	if _, err := svc.RecomputeRating(ctx, 99); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"opentracing-sample/cache"
+	. "opentracing-sample/config"
+	"strconv"
+	"time"
+)
+
+const cacheTTL = 5 * time.Minute
+
+// Service 是商品目录的业务层，Get 走 cache-aside，写操作会让缓存失效
+type Service struct {
+	repo  Repository
+	cache cache.Cache
+}
+
+// NewService 创建服务，c 为 nil 时不使用缓存
+func NewService(repo Repository, c cache.Cache) *Service {
+	return &Service{repo: &tracedRepository{repo}, cache: c}
+}
+
+func cacheKey(id int64) string {
+	return "product:" + strconv.FormatInt(id, 10)
+}
+
+func (s *Service) List(ctx context.Context, f Filter) (Page, error) {
+	span, ctx := startSpan(ctx, "ProductService.List")
+	page, err := s.repo.List(ctx, f)
+	finish(span, err)
+	return page, err
+}
+
+func (s *Service) Get(ctx context.Context, id int64) (*Product, error) {
+	span, ctx := startSpan(ctx, "ProductService.Get")
+	p, err := s.get(ctx, id)
+	finish(span, err)
+	return p, err
+}
+
+func (s *Service) get(ctx context.Context, id int64) (*Product, error) {
+	if s.cache == nil {
+		return s.repo.Get(ctx, id)
+	}
+
+	b, err := s.cache.Get(ctx, cacheKey(id))
+	if err == nil {
+		p := &Product{}
+		if err := json.Unmarshal(b, p); err == nil {
+			return p, nil
+		}
+	} else if err != cache.ErrMiss {
+		Log.WithField("component", "product").Warn(err)
+	}
+
+	p, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := json.Marshal(p); err == nil {
+		if err := s.cache.Set(ctx, cacheKey(id), b, cacheTTL); err != nil {
+			Log.WithField("component", "product").Warn(err)
+		}
+	}
+	return p, nil
+}
+
+func (s *Service) Create(ctx context.Context, p *Product) error {
+	span, ctx := startSpan(ctx, "ProductService.Create")
+	err := p.Validate()
+	if err == nil {
+		err = s.repo.Create(ctx, p)
+	}
+	finish(span, err)
+	return err
+}
+
+func (s *Service) Update(ctx context.Context, p *Product) error {
+	span, ctx := startSpan(ctx, "ProductService.Update")
+	err := p.Validate()
+	if err == nil {
+		err = s.repo.Update(ctx, p)
+	}
+	if err == nil {
+		s.invalidate(ctx, p.ID)
+	}
+	finish(span, err)
+	return err
+}
+
+func (s *Service) Delete(ctx context.Context, id int64) error {
+	span, ctx := startSpan(ctx, "ProductService.Delete")
+	err := s.repo.Delete(ctx, id)
+	if err == nil {
+		s.invalidate(ctx, id)
+	}
+	finish(span, err)
+	return err
+}
+
+func (s *Service) ListReviews(ctx context.Context, productID int64) ([]Review, error) {
+	span, ctx := startSpan(ctx, "ProductService.ListReviews")
+	reviews, err := s.repo.ListReviews(ctx, productID)
+	finish(span, err)
+	return reviews, err
+}
+
+func (s *Service) AddReview(ctx context.Context, r *Review) error {
+	span, ctx := startSpan(ctx, "ProductService.AddReview")
+	err := r.Validate()
+	if err == nil {
+		err = s.repo.AddReview(ctx, r)
+	}
+	finish(span, err)
+	return err
+}
+
+// RecomputeRating 按所有评论重新计算商品的平均分，保留两位小数，没有评论时为 0
+func (s *Service) RecomputeRating(ctx context.Context, id int64) (float64, error) {
+	span, ctx := startSpan(ctx, "ProductService.RecomputeRating")
+	rating, err := s.repo.RecomputeRating(ctx, id)
+	if err == nil {
+		span.SetTag("product.rating", rating)
+		s.invalidate(ctx, id)
+	}
+	finish(span, err)
+	return rating, err
+}
+
+func (s *Service) invalidate(ctx context.Context, id int64) {
+	if s.cache == nil {
+		return
+	}
+	if err := s.cache.Delete(ctx, cacheKey(id)); err != nil {
+		Log.WithField("component", "product").Warn(err)
+	}
+}
+
+func startSpan(ctx context.Context, operation string) (opentracing.Span, context.Context) {
+	return opentracing.StartSpanFromContext(ctx, operation)
+}
+
+// finish 结束 span，未找到和参数错误是正常的业务结果，不标记为 error
+func finish(span opentracing.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrInvalid) {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	span.Finish()
+}
+
+// tracedRepository 为仓储的每个方法创建 span
+type tracedRepository struct {
+	Repository
+}
+
+func (r *tracedRepository) List(ctx context.Context, f Filter) (Page, error) {
+	span, ctx := startSpan(ctx, "ProductRepository.List")
+	page, err := r.Repository.List(ctx, f)
+	span.SetTag("product.count", len(page.Items))
+	finish(span, err)
+	return page, err
+}
+
+func (r *tracedRepository) Get(ctx context.Context, id int64) (*Product, error) {
+	span, ctx := startSpan(ctx, "ProductRepository.Get")
+	span.SetTag("product.id", id)
+	p, err := r.Repository.Get(ctx, id)
+	finish(span, err)
+	return p, err
+}
+
+func (r *tracedRepository) Create(ctx context.Context, p *Product) error {
+	span, ctx := startSpan(ctx, "ProductRepository.Create")
+	err := r.Repository.Create(ctx, p)
+	span.SetTag("product.id", p.ID)
+	finish(span, err)
+	return err
+}
+
+func (r *tracedRepository) Update(ctx context.Context, p *Product) error {
+	span, ctx := startSpan(ctx, "ProductRepository.Update")
+	span.SetTag("product.id", p.ID)
+	err := r.Repository.Update(ctx, p)
+	finish(span, err)
+	return err
+}
+
+func (r *tracedRepository) Delete(ctx context.Context, id int64) error {
+	span, ctx := startSpan(ctx, "ProductRepository.Delete")
+	span.SetTag("product.id", id)
+	err := r.Repository.Delete(ctx, id)
+	finish(span, err)
+	return err
+}
+
+func (r *tracedRepository) ListReviews(ctx context.Context, productID int64) ([]Review, error) {
+	span, ctx := startSpan(ctx, "ProductRepository.ListReviews")
+	span.SetTag("product.id", productID)
+	reviews, err := r.Repository.ListReviews(ctx, productID)
+	finish(span, err)
+	return reviews, err
+}
+
+func (r *tracedRepository) AddReview(ctx context.Context, rv *Review) error {
+	span, ctx := startSpan(ctx, "ProductRepository.AddReview")
+	span.SetTag("product.id", rv.ProductID)
+	err := r.Repository.AddReview(ctx, rv)
+	finish(span, err)
+	return err
+}
+
+func (r *tracedRepository) RecomputeRating(ctx context.Context, id int64) (float64, error) {
+	span, ctx := startSpan(ctx, "ProductRepository.RecomputeRating")
+	span.SetTag("product.id", id)
+	rating, err := r.Repository.RecomputeRating(ctx, id)
+	finish(span, err)
+	return rating, err
+}
@@ -0,0 +1,242 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var schemas = map[string][]string{
+	"mysql": {
+		`CREATE TABLE IF NOT EXISTS product (
+			id          BIGINT PRIMARY KEY AUTO_INCREMENT,
+			name        VARCHAR(255) NOT NULL,
+			description TEXT NOT NULL,
+			price       DECIMAL(10, 2) NOT NULL,
+			rating      DECIMAL(3, 2) NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS review (
+			id         BIGINT PRIMARY KEY AUTO_INCREMENT,
+			product_id BIGINT NOT NULL,
+			author     VARCHAR(255) NOT NULL,
+			rating     TINYINT NOT NULL,
+			comment    TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_review_product (product_id)
+		)`,
+	},
+	"sqlite": {
+		`CREATE TABLE IF NOT EXISTS product (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			name        TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			price       REAL NOT NULL,
+			rating      REAL NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS review (
+			id         INTEGER PRIMARY KEY AUTOINCREMENT,
+			product_id INTEGER NOT NULL,
+			author     TEXT NOT NULL,
+			rating     INTEGER NOT NULL,
+			comment    TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_review_product ON review (product_id)`,
+	},
+}
+
+// Migrate 按方言（mysql 或 sqlite）创建表
+func Migrate(ctx context.Context, db *sql.DB, dialect string) error {
+	stmts, ok := schemas[dialect]
+	if !ok {
+		return fmt.Errorf("product: unsupported dialect %q", dialect)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// likeEscaper 转义 LIKE 的通配符。mysql 字符串中的反斜杠本身要转义，用 ! 作转义符两种方言写法一致
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// SQLRepository 是基于 database/sql 的仓储实现，SQL 只使用 mysql 和 sqlite 都支持的语法
+type SQLRepository struct {
+	db *sql.DB
+}
+
+func NewSQLRepository(db *sql.DB) *SQLRepository {
+	return &SQLRepository{db: db}
+}
+
+func (r *SQLRepository) List(ctx context.Context, f Filter) (Page, error) {
+	f = f.normalize()
+	var (
+		where []string
+		args  []interface{}
+	)
+	if f.Name != "" {
+		where = append(where, `LOWER(name) LIKE ? ESCAPE '!'`)
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(f.Name))+"%")
+	}
+	if f.MinPrice > 0 {
+		where = append(where, "price >= ?")
+		args = append(args, f.MinPrice)
+	}
+	if f.MaxPrice > 0 {
+		where = append(where, "price <= ?")
+		args = append(args, f.MaxPrice)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := Page{Items: []Product{}, Offset: f.Offset, Limit: f.Limit}
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM product"+cond, args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, name, description, price, rating FROM product"+cond+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Rating); err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, p)
+	}
+	return page, rows.Err()
+}
+
+func (r *SQLRepository) Get(ctx context.Context, id int64) (*Product, error) {
+	p := &Product{}
+	err := r.db.QueryRowContext(ctx, `SELECT id, name, description, price, rating FROM product WHERE id = ?`, id).
+		Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Rating)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *SQLRepository) Create(ctx context.Context, p *Product) error {
+	var (
+		res sql.Result
+		err error
+	)
+	if p.ID == 0 {
+		res, err = r.db.ExecContext(ctx, `INSERT INTO product (name, description, price) VALUES (?, ?, ?)`,
+			p.Name, p.Description, p.Price)
+	} else {
+		res, err = r.db.ExecContext(ctx, `INSERT INTO product (id, name, description, price) VALUES (?, ?, ?, ?)`,
+			p.ID, p.Name, p.Description, p.Price)
+	}
+	if err != nil {
+		return err
+	}
+	if p.ID == 0 {
+		p.ID, err = res.LastInsertId()
+	}
+	return err
+}
+
+func (r *SQLRepository) Update(ctx context.Context, p *Product) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE product SET name = ?, description = ?, price = ? WHERE id = ?`,
+		p.Name, p.Description, p.Price, p.ID)
+	if err != nil {
+		return err
+	}
+	// mysql 在值没有变化时返回 0，需要再确认一次是否存在
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		_, err := r.Get(ctx, p.ID)
+		return err
+	}
+	return nil
+}
+
+func (r *SQLRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM product WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM review WHERE product_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLRepository) ListReviews(ctx context.Context, productID int64) ([]Review, error) {
+	if _, err := r.Get(ctx, productID); err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, product_id, author, rating, comment, created_at FROM review WHERE product_id = ? ORDER BY id`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		var rv Review
+		if err := rows.Scan(&rv.ID, &rv.ProductID, &rv.Author, &rv.Rating, &rv.Comment, &rv.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rv)
+	}
+	return reviews, rows.Err()
+}
+
+func (r *SQLRepository) AddReview(ctx context.Context, rv *Review) error {
+	if _, err := r.Get(ctx, rv.ProductID); err != nil {
+		return err
+	}
+	if rv.CreatedAt.IsZero() {
+		rv.CreatedAt = time.Now().UTC()
+	}
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO review (product_id, author, rating, comment, created_at) VALUES (?, ?, ?, ?, ?)`,
+		rv.ProductID, rv.Author, rv.Rating, rv.Comment, rv.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rv.ID, err = res.LastInsertId()
+	return err
+}
+
+// RecomputeRating 在一条 UPDATE 中计算平均分，并发执行时后执行的语句总能看到全部已提交的评论
+func (r *SQLRepository) RecomputeRating(ctx context.Context, id int64) (float64, error) {
+	_, err := r.db.ExecContext(ctx, `UPDATE product SET rating = COALESCE(
+			(SELECT ROUND(AVG(rating), 2) FROM review WHERE product_id = ?), 0) WHERE id = ?`, id, id)
+	if err != nil {
+		return 0, err
+	}
+	p, err := r.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return p.Rating, nil
+}
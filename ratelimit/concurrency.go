@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ConcurrencyConfig 配置自适应并发限制。Max 为 0 时不限制。
+// 请求延迟不超过 TargetLatency 时上限缓慢增加，超过时按比例下降，在 Min 和 Max 之间浮动。
+type ConcurrencyConfig struct {
+	Min           int      `json:"min"`
+	Max           int      `json:"max"`
+	TargetLatency Duration `json:"target_latency"`
+}
+
+// Concurrency 是 AIMD 方式的自适应并发限制，延迟升高时丢弃超出上限的请求
+type Concurrency struct {
+	mu       sync.Mutex
+	cfg      ConcurrencyConfig
+	limit    float64
+	inflight int
+	now      func() time.Time
+}
+
+// NewConcurrency 按 cfg 创建并发限制，初始上限为 Max
+func NewConcurrency(cfg ConcurrencyConfig) *Concurrency {
+	c := &Concurrency{now: time.Now}
+	c.Update(cfg)
+	return c
+}
+
+// Update 替换配置并把上限重置为 Max
+func (c *Concurrency) Update(cfg ConcurrencyConfig) {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	c.mu.Lock()
+	c.cfg = cfg
+	c.limit = float64(cfg.Max)
+	c.mu.Unlock()
+}
+
+// Limit 返回当前的并发上限
+func (c *Concurrency) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// Acquire 在并发数未达上限时占用一个名额
+func (c *Concurrency) Acquire() (release func(), ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.Max == 0 {
+		return func() {}, true
+	}
+	if c.inflight >= int(c.limit) {
+		return nil, false
+	}
+	c.inflight++
+	start := c.now()
+	var once sync.Once
+	return func() {
+		once.Do(func() { c.release(c.now().Sub(start)) })
+	}, true
+}
+
+func (c *Concurrency) release(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	if c.cfg.Max == 0 || c.cfg.TargetLatency == 0 {
+		return
+	}
+	if latency > time.Duration(c.cfg.TargetLatency) {
+		c.limit = math.Max(float64(c.cfg.Min), c.limit*0.9)
+	} else {
+		c.limit = math.Min(float64(c.cfg.Max), c.limit+1/c.limit)
+	}
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"opentracing-sample/config"
+	"os"
+	"sync"
+	"time"
+)
+
+// Duration 在 JSON 中写作 "250ms" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadFile 读取 JSON 格式的限流配置
+func LoadFile(path string) (Config, error) {
+	var cfg Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("ratelimit: parse %s: %v", path, err)
+	}
+	for _, r := range cfg.Rules {
+		if r.Name == "" || r.Match == "" || r.Rate <= 0 {
+			return cfg, fmt.Errorf("ratelimit: invalid rule %+v in %s", r, path)
+		}
+		switch r.Key {
+		case "", KeyIP, KeyToken, KeyTenant:
+		default:
+			return cfg, fmt.Errorf("ratelimit: rule %s in %s has unknown key %q", r.Name, path, r.Key)
+		}
+	}
+	// Max 为 0 表示不限制并发，此时不检查 Min
+	if c := cfg.Concurrency; c.Min < 0 || c.Max < 0 || (c.Max > 0 && c.Min > c.Max) {
+		return cfg, fmt.Errorf("ratelimit: invalid concurrency min %d, max %d in %s", c.Min, c.Max, path)
+	}
+	return cfg, nil
+}
+
+// Watch 在后台每隔 interval 检查配置文件，修改后重新加载；加载失败时保留原配置。调用返回的 stop 停止监视。
+func (l *Limiter) Watch(path string, interval time.Duration) (stop func()) {
+	var modTime time.Time
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+	done := make(chan struct{})
+	go l.watch(path, interval, modTime, done)
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (l *Limiter) watch(path string, interval time.Duration, modTime time.Time, done <-chan struct{}) {
+	log := config.Log.WithField("component", "ratelimit")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().After(modTime) {
+			continue
+		}
+		modTime = fi.ModTime()
+		cfg, err := LoadFile(path)
+		if err != nil {
+			log.Errorf("reload failed, keeping previous limits: %v", err)
+			continue
+		}
+		l.Update(cfg)
+		log.Infof("reloaded %d rules from %s", len(cfg.Rules), path)
+	}
+}
+
+// FromEnv 用 RATELIMIT_CONFIG 指向的文件创建 Limiter 并开始监视文件变化；未设置时不做任何限制
+func FromEnv() (*Limiter, error) {
+	path := os.Getenv("RATELIMIT_CONFIG")
+	if path == "" {
+		return New(Config{}), nil
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := New(cfg)
+	l.Watch(path, 10*time.Second)
+	return l, nil
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"opentracing-sample/tenant"
+)
+
+// grpcKeys 从 peer 地址、authorization metadata 和上下文中的租户得到客户端标识
+func grpcKeys(ctx context.Context) Keys {
+	keys := Keys{KeyTenant: tenant.FromContext(ctx)}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		keys[KeyIP] = host
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get("authorization"); len(vs) > 0 {
+			keys[KeyToken] = vs[0]
+		}
+	}
+	return keys
+}
+
+// admit 依次检查令牌桶和并发限制，被拒绝时打标签并返回对应的状态码
+func (l *Limiter) admit(ctx context.Context, method string) (func(), error) {
+	span := opentracing.SpanFromContext(ctx)
+	if name, ok := l.Allow(method, grpcKeys(ctx)); !ok {
+		Reject(span, name)
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limited by %s", name)
+	}
+	release, ok := l.Acquire()
+	if !ok {
+		Reject(span, ConcurrencyLimiter)
+		return nil, status.Error(codes.Unavailable, "server overloaded")
+	}
+	return release, nil
+}
+
+// UnaryServerInterceptor 按方法名限流，需要放在 opentracing 和 tenant 拦截器之后
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := l.admit(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 是 UnaryServerInterceptor 的流式版本，并发名额在整个流结束时释放
+func StreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.admit(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
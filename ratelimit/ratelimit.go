@@ -0,0 +1,165 @@
+// Package ratelimit 为 gin-sample 和 grpc-server 提供令牌桶限流和自适应并发限制，
+// 被拒绝的请求会打在 span 上并计入 expvar 指标
+package ratelimit
+
+import (
+	"expvar"
+	"github.com/opentracing/opentracing-go"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 被拒绝请求的 span 标签
+const (
+	TagRateLimited = "ratelimited"
+	TagLimiter     = "limiter"
+)
+
+// ConcurrencyLimiter 是自适应并发限制被触发时使用的 limiter 名字
+const ConcurrencyLimiter = "concurrency"
+
+// 按客户端区分令牌桶时可用的 key
+const (
+	KeyIP     = "ip"
+	KeyToken  = "token"
+	KeyTenant = "tenant"
+)
+
+// Rejected 按 limiter 名字统计被拒绝的请求数，通过 /debug/vars 暴露
+var Rejected = expvar.NewMap("ratelimit_rejected")
+
+// maxBuckets 超过后清理已经回满的桶，避免按客户端建桶时无限增长
+const maxBuckets = 10000
+
+// Rule 是一条令牌桶规则。Match 是 gin 路由（FullPath）或 gRPC 方法全名，* 结尾表示前缀匹配；
+// Key 为 ip、token 或 tenant 时每个客户端一个桶，为空时所有客户端共用一个桶。
+type Rule struct {
+	Name  string  `json:"name"`
+	Match string  `json:"match"`
+	Key   string  `json:"key"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (r Rule) matches(route string) bool {
+	if strings.HasSuffix(r.Match, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(r.Match, "*"))
+	}
+	return r.Match == route
+}
+
+func (r Rule) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return math.Max(1, math.Ceil(r.Rate))
+}
+
+// Config 是限流的完整配置，可以整体替换
+type Config struct {
+	Rules       []Rule            `json:"rules"`
+	Concurrency ConcurrencyConfig `json:"concurrency"`
+}
+
+// Keys 是一次请求的客户端标识，key 为 KeyIP、KeyToken、KeyTenant
+type Keys map[string]string
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(r Rule, now time.Time) {
+	b.tokens = math.Min(r.burst(), b.tokens+now.Sub(b.last).Seconds()*r.Rate)
+	b.last = now
+}
+
+// Limiter 组合令牌桶规则和自适应并发限制，所有方法都可以并发调用
+type Limiter struct {
+	mu      sync.Mutex
+	rules   []Rule
+	buckets map[string]*bucket
+	now     func() time.Time
+
+	concurrency *Concurrency
+}
+
+// New 按 cfg 创建 Limiter，零值 Config 不做任何限制
+func New(cfg Config) *Limiter {
+	l := &Limiter{now: time.Now, concurrency: NewConcurrency(ConcurrencyConfig{})}
+	l.Update(cfg)
+	return l
+}
+
+// Update 替换限流配置，可以在运行时调用；令牌桶会重新开始计数
+func (l *Limiter) Update(cfg Config) {
+	rules := append([]Rule(nil), cfg.Rules...)
+	l.mu.Lock()
+	l.rules = rules
+	l.buckets = map[string]*bucket{}
+	l.mu.Unlock()
+	l.concurrency.Update(cfg.Concurrency)
+}
+
+// Allow 检查 route 上所有匹配的规则，只有全部有令牌时才扣减；被拒绝时返回拒绝它的规则名
+func (l *Limiter) Allow(route string, keys Keys) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var matched []*bucket
+	for _, r := range l.rules {
+		if !r.matches(route) {
+			continue
+		}
+		id := r.Name + "\x00" + keys[r.Key]
+		b, ok := l.buckets[id]
+		if !ok {
+			b = &bucket{tokens: r.burst(), last: now}
+			l.buckets[id] = b
+		}
+		b.refill(r, now)
+		if b.tokens < 1 {
+			return r.Name, false
+		}
+		matched = append(matched, b)
+	}
+	for _, b := range matched {
+		b.tokens--
+	}
+	if len(l.buckets) > maxBuckets {
+		l.gc(now)
+	}
+	return "", true
+}
+
+// gc 删除已经回满的桶，它们和新建的桶没有区别
+func (l *Limiter) gc(now time.Time) {
+	for _, r := range l.rules {
+		prefix := r.Name + "\x00"
+		for id, b := range l.buckets {
+			if strings.HasPrefix(id, prefix) {
+				b.refill(r, now)
+				if b.tokens >= r.burst() {
+					delete(l.buckets, id)
+				}
+			}
+		}
+	}
+}
+
+// Acquire 占用一个并发名额，成功时返回的 release 必须在请求结束后调用
+func (l *Limiter) Acquire() (release func(), ok bool) {
+	return l.concurrency.Acquire()
+}
+
+// Reject 把被拒绝的请求打在 span 上并计数
+func Reject(span opentracing.Span, limiter string) {
+	Rejected.Add(limiter, 1)
+	if span != nil {
+		span.SetTag(TagRateLimited, true)
+		span.SetTag(TagLimiter, limiter)
+	}
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(cfg Config) (*Limiter, *clock) {
+	c := &clock{t: time.Unix(0, 0)}
+	l := New(cfg)
+	l.now = c.now
+	l.concurrency.now = c.now
+	return l, c
+}
+
+func TestTokenBucketPerKey(t *testing.T) {
+	l, clk := newTestLimiter(Config{Rules: []Rule{
+		{Name: "product-ip", Match: "/api/product*", Key: KeyIP, Rate: 1, Burst: 2},
+	}})
+	alice := Keys{KeyIP: "10.0.0.1"}
+	bob := Keys{KeyIP: "10.0.0.2"}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Allow("/api/product/:id", alice); !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	if name, ok := l.Allow("/api/product", alice); ok || name != "product-ip" {
+		t.Fatalf("expected rejection by product-ip, got %q %v", name, ok)
+	}
+	if _, ok := l.Allow("/api/product", bob); !ok {
+		t.Fatal("other client should have its own bucket")
+	}
+	if _, ok := l.Allow("/debug/vars", alice); !ok {
+		t.Fatal("unmatched route should not be limited")
+	}
+
+	clk.advance(time.Second)
+	if _, ok := l.Allow("/api/product", alice); !ok {
+		t.Fatal("bucket should refill over time")
+	}
+}
+
+func TestAllowConsumesOnlyWhenAllRulesPass(t *testing.T) {
+	l, _ := newTestLimiter(Config{Rules: []Rule{
+		{Name: "global", Match: "*", Rate: 1, Burst: 2},
+		{Name: "tenant", Match: "*", Key: KeyTenant, Rate: 1, Burst: 1},
+	}})
+	acme := Keys{KeyTenant: "acme"}
+	if _, ok := l.Allow("/helloworld.Greeter/SayHello", acme); !ok {
+		t.Fatal("first request rejected")
+	}
+	if name, _ := l.Allow("/helloworld.Greeter/SayHello", acme); name != "tenant" {
+		t.Fatalf("expected tenant rejection, got %q", name)
+	}
+	// 被 tenant 规则拒绝的请求不应扣减 global 的令牌
+	if _, ok := l.Allow("/helloworld.Greeter/SayHello", Keys{KeyTenant: "globex"}); !ok {
+		t.Fatal("global bucket drained by rejected request")
+	}
+	if _, ok := l.Allow("/helloworld.Greeter/SayHello", Keys{KeyTenant: "initech"}); ok {
+		t.Fatal("global bucket should be empty")
+	}
+
+	l.Update(Config{})
+	if _, ok := l.Allow("/helloworld.Greeter/SayHello", acme); !ok {
+		t.Fatal("limits should be lifted after Update")
+	}
+}
+
+func TestConcurrencyAdapts(t *testing.T) {
+	l, clk := newTestLimiter(Config{Concurrency: ConcurrencyConfig{
+		Min: 1, Max: 2, TargetLatency: Duration(100 * time.Millisecond),
+	}})
+	r1, ok1 := l.Acquire()
+	r2, ok2 := l.Acquire()
+	if !ok1 || !ok2 {
+		t.Fatal("requests within limit rejected")
+	}
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("request over limit admitted")
+	}
+
+	clk.advance(time.Second)
+	r1()
+	r2()
+	if got := l.concurrency.Limit(); got != 1 {
+		t.Fatalf("limit should shrink when latency is high, got %d", got)
+	}
+	r, _ := l.Acquire()
+	if _, ok := l.Acquire(); ok {
+		t.Fatal("shrunk limit not enforced")
+	}
+	r()
+	r, _ = l.Acquire()
+	r()
+	if got := l.concurrency.Limit(); got != 2 {
+		t.Fatalf("limit should grow when latency is low, got %d", got)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l, _ := newTestLimiter(Config{Rules: []Rule{
+		{Name: "say-hello", Match: "/helloworld.Greeter/SayHello", Rate: 1, Burst: 1},
+	}})
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("/helloworld.Greeter/SayHello")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	info := &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	if _, err := UnaryServerInterceptor(l)(ctx, nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	_, err := UnaryServerInterceptor(l)(ctx, nil, info, handler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	span.Finish()
+	tags := tracer.FinishedSpans()[0].Tags()
+	if tags[TagRateLimited] != true || tags[TagLimiter] != "say-hello" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+}
+
+func TestLoadFileAndWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ratelimit.json")
+
+	write := func(content string, mtime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	write(`{"rules":[{"name":"all","match":"*","rate":0.001,"burst":1}],"concurrency":{"max":10,"target_latency":"250ms"}}`,
+		time.Now().Add(-time.Minute))
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules) != 1 || cfg.Concurrency.TargetLatency != Duration(250*time.Millisecond) {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	l := New(cfg)
+	stop := l.Watch(path, 5*time.Millisecond)
+	defer stop()
+
+	l.Allow("/", nil)
+	if _, ok := l.Allow("/", nil); ok {
+		t.Fatal("limit from file not applied")
+	}
+	write(`{"rules":[]}`, time.Now())
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := l.Allow("/", nil); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("config not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, content := range []string{
+		`{"rules":[{"name":"bad"}]}`,
+		`{"rules":[{"name":"per-user","match":"*","rate":1,"key":"user"}]}`,
+		`{"concurrency":{"min":20,"max":10}}`,
+		`{"concurrency":{"min":-1}}`,
+	} {
+		write(content, time.Now().Add(time.Minute))
+		if _, err := LoadFile(path); err == nil {
+			t.Fatalf("invalid config accepted: %s", content)
+		}
+	}
+	write(`{"rules":[{"name":"per-tenant","match":"*","rate":1,"key":"tenant"}],"concurrency":{"min":20}}`, time.Now())
+	if _, err := LoadFile(path); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package recovery
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInternal 是 panic 后返回给客户端的错误，不带 panic 的细节
+var ErrInternal = status.Error(codes.Internal, "internal error")
+
+// UnaryServerInterceptor 恢复 handler 的 panic 并返回 codes.Internal，需要放在 opentracing 拦截器之后
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				Record(ctx, "grpc", p)
+				err = ErrInternal
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 是 UnaryServerInterceptor 的流式版本
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				Record(ss.Context(), "grpc", p)
+				err = ErrInternal
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,35 @@
+// Package recovery 把 handler 中的 panic 转成错误响应，同时记录到当前 span、日志和计数器上
+package recovery
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/sirupsen/logrus"
+	"opentracing-sample/config"
+	"runtime/debug"
+)
+
+// Panics 按组件（http、grpc）统计恢复的 panic 次数，通过 /debug/vars 暴露
+var Panics = expvar.NewMap("panics")
+
+// Record 记录一次 panic：当前 span 标记 error 并记下 panic 值和堆栈，打印带 trace_id 的日志，计数加一
+func Record(ctx context.Context, component string, p interface{}) {
+	stack := string(debug.Stack())
+	Panics.Add(component, 1)
+
+	fields := logrus.Fields{"component": component, "panic": fmt.Sprint(p)}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(
+			log.String("event", "panic"),
+			log.String("panic", fmt.Sprint(p)),
+			log.String("stack", stack),
+		)
+		fields["trace_id"] = config.TraceID(span)
+	}
+	config.Log.WithFields(fields).Errorf("recovered from panic: %v\n%s", p, stack)
+}
@@ -0,0 +1,90 @@
+package recovery
+
+import (
+	"context"
+	"expvar"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s stream) Context() context.Context {
+	return s.ctx
+}
+
+func count(component string) int64 {
+	if v, ok := Panics.Get(component).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func checkSpan(t *testing.T, span *mocktracer.MockSpan) {
+	t.Helper()
+	if span.Tag("error") != true {
+		t.Fatalf("error tag not set: %v", span.Tags())
+	}
+	logs := span.Logs()
+	if len(logs) != 1 {
+		t.Fatalf("expected one span log, got %v", logs)
+	}
+	fields := map[string]string{}
+	for _, f := range logs[0].Fields {
+		fields[f.Key] = f.ValueString
+	}
+	if fields["event"] != "panic" || fields["panic"] != "boom" || fields["stack"] == "" {
+		t.Fatalf("unexpected log fields %v", fields)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("/helloworld.Greeter/SayHello")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	before := count("grpc")
+
+	_, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") })
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected codes.Internal, got %v", err)
+	}
+	span.Finish()
+	checkSpan(t, tracer.FinishedSpans()[0])
+	if count("grpc") != before+1 {
+		t.Fatal("panic counter not incremented")
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("/helloworld.Greeter/SayHelloStream")
+	ss := stream{ctx: opentracing.ContextWithSpan(context.Background(), span)}
+	before := count("grpc")
+
+	err := StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{},
+		func(srv interface{}, ss grpc.ServerStream) error { panic("boom") })
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected codes.Internal, got %v", err)
+	}
+	span.Finish()
+	checkSpan(t, tracer.FinishedSpans()[0])
+	if count("grpc") != before+1 {
+		t.Fatal("panic counter not incremented")
+	}
+}
+
+func TestRecordWithoutSpan(t *testing.T) {
+	before := count("http")
+	Record(context.Background(), "http", "boom")
+	if count("http") != before+1 {
+		t.Fatal("panic counter not incremented")
+	}
+}
@@ -0,0 +1,29 @@
+package redact
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Hook 是 logrus 钩子，在格式化输出之前遮盖日志消息和字段
+type Hook struct {
+	policy *Policy
+}
+
+// NewHook 创建遮盖日志的钩子
+func NewHook(policy *Policy) *Hook {
+	return &Hook{policy: policy}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.policy.String(entry.Message)
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = h.policy.Value(k, v)
+	}
+	entry.Data = data
+	return nil
+}
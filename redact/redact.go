@@ -0,0 +1,151 @@
+// Package redact 在 span 标签、span 日志和应用日志写出之前遮盖敏感信息
+package redact
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Mask 是替换敏感内容的占位符
+const Mask = "[REDACTED]"
+
+// Policy 描述需要遮盖的请求头、标签/字段名和正则模式
+type Policy struct {
+	// Headers 中的请求头整体遮盖，不区分大小写
+	Headers []string
+	// Keys 中的标签或日志字段整体遮盖；key 等于它或以 .<key>/-<key>/_<key> 结尾都算匹配
+	Keys []string
+	// Patterns 匹配到的片段会被遮盖，适用于所有字符串值
+	Patterns []*Pattern
+}
+
+// Pattern 是一条遮盖规则，Valid 不为空时只有通过校验的匹配才会被遮盖
+type Pattern struct {
+	Name  string
+	Re    *regexp.Regexp
+	Valid func(match string) bool
+	// Keep 为 true 时保留第一个分组（如 password=）只遮盖后面的值
+	Keep bool
+}
+
+// Default 是默认策略，覆盖 Authorization/Cookie 以及邮箱、令牌和银行卡号
+var Default = &Policy{
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"},
+	Keys:    []string{"authorization", "cookie", "set-cookie", "password", "passwd", "secret", "token", "api_key", "apikey"},
+	Patterns: []*Pattern{
+		{Name: "jwt", Re: regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)},
+		{Name: "bearer", Re: regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), Keep: true},
+		{Name: "secret", Re: regexp.MustCompile(`(?i)((?:password|passwd|secret|token|api[_-]?key|\w*session\w*)=)[^&\s;\]]+`), Keep: true},
+		// 按 %+v 打印的 http.Header 中的 Cookie/Authorization
+		{Name: "header", Re: regexp.MustCompile(`(?i)((?:cookie|authorization):\[)[^\]]*`), Keep: true},
+		{Name: "email", Re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+		{Name: "card", Re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Valid: luhn},
+	},
+}
+
+// String 按 Patterns 遮盖字符串中的敏感片段
+func (p *Policy) String(s string) string {
+	for _, pat := range p.Patterns {
+		if pat.Keep {
+			s = pat.Re.ReplaceAllStringFunc(s, func(m string) string {
+				sub := pat.Re.FindStringSubmatch(m)
+				if len(sub) > 1 {
+					return sub[1] + Mask
+				}
+				return Mask
+			})
+			continue
+		}
+		s = pat.Re.ReplaceAllStringFunc(s, func(m string) string {
+			if pat.Valid != nil && !pat.Valid(m) {
+				return m
+			}
+			return Mask
+		})
+	}
+	return s
+}
+
+// SensitiveKey 判断标签或字段名是否需要整体遮盖
+func (p *Policy) SensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range p.Keys {
+		if key == k || strings.HasSuffix(key, "."+k) || strings.HasSuffix(key, "-"+k) || strings.HasSuffix(key, "_"+k) {
+			return true
+		}
+	}
+	return false
+}
+
+// SensitiveHeader 判断请求头是否需要整体遮盖
+func (p *Policy) SensitiveHeader(name string) bool {
+	for _, h := range p.Headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Value 返回遮盖后的值：敏感 key 整体遮盖，字符串和 Stringer 按模式遮盖，其他类型原样返回
+func (p *Policy) Value(key string, v interface{}) interface{} {
+	if p.SensitiveKey(key) {
+		return Mask
+	}
+	switch val := v.(type) {
+	case string:
+		return p.String(val)
+	case []byte:
+		return p.String(string(val))
+	case error:
+		return p.String(val.Error())
+	case fmt.Stringer:
+		return p.String(val.String())
+	}
+	return v
+}
+
+// Header 返回遮盖后的请求头副本
+func (p *Policy) Header(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		out[k] = p.Values(k, vs)
+	}
+	return out
+}
+
+// Values 遮盖同一个请求头或 metadata key 的多个值
+func (p *Policy) Values(name string, vs []string) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		if p.SensitiveHeader(name) || p.SensitiveKey(name) {
+			out[i] = Mask
+		} else {
+			out[i] = p.String(v)
+		}
+	}
+	return out
+}
+
+// luhn 校验银行卡号，避免把普通的长数字当成卡号
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,92 @@
+package redact
+
+import (
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/uber/jaeger-client-go"
+	"reflect"
+	"unsafe"
+)
+
+// jaeger.Span 没有替换标签和日志的方法，只能通过反射改写这两个未导出字段；
+// 升级 jaeger-client-go 后字段不存在时在包初始化时 panic，而不是悄悄漏掉遮盖
+var spanFields = func() map[string]int {
+	t := reflect.TypeOf(jaeger.Span{})
+	fields := map[string]int{}
+	for _, name := range []string{"tags", "logs"} {
+		f, ok := t.FieldByName(name)
+		if !ok {
+			panic("redact: jaeger.Span has no field " + name)
+		}
+		fields[name] = f.Index[0]
+	}
+	return fields
+}()
+
+// Reporter 是 jaeger.Reporter 的装饰器，span 的标签和日志按策略遮盖后再交给 next。
+// 在 reporter 中遮盖，任何 tracer 包装器之外直接创建的 span 也会经过
+type Reporter struct {
+	next   jaeger.Reporter
+	policy *Policy
+}
+
+// NewReporter 创建装饰器
+func NewReporter(next jaeger.Reporter, policy *Policy) *Reporter {
+	return &Reporter{next: next, policy: policy}
+}
+
+func (r *Reporter) Report(s *jaeger.Span) {
+	r.redact(s)
+	r.next.Report(s)
+}
+
+func (r *Reporter) Close() {
+	r.next.Close()
+}
+
+// redact 改写已结束的 span。同名标签只保留最后一个值，与 Span.Tags 的结果一致
+func (r *Reporter) redact(s *jaeger.Span) {
+	values := s.Tags()
+	logs := s.Logs()
+	for i, rec := range logs {
+		logs[i].Fields = r.fields(rec.Fields)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	v := reflect.ValueOf(s).Elem()
+	raw := v.Field(spanFields["tags"])
+	tags := make([]jaeger.Tag, 0, raw.Len())
+	seen := make(map[string]bool, raw.Len())
+	for i := 0; i < raw.Len(); i++ {
+		// Tag 的第一个字段是 key，未导出字段也可以用 String 读取
+		key := raw.Index(i).Field(0).String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		tags = append(tags, jaeger.NewTag(key, r.policy.Value(key, values[key])))
+	}
+	set(raw, reflect.ValueOf(tags))
+	set(v.Field(spanFields["logs"]), reflect.ValueOf(logs))
+}
+
+func set(field, value reflect.Value) {
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(value)
+}
+
+// fields 返回遮盖后的副本，不修改传入的切片
+func (r *Reporter) fields(fields []log.Field) []log.Field {
+	out := make([]log.Field, len(fields))
+	for i, f := range fields {
+		out[i] = f
+		v := f.Value()
+		rv, ok := r.policy.Value(f.Key(), v).(string)
+		if !ok {
+			continue
+		}
+		if orig, isString := v.(string); !isString || orig != rv {
+			out[i] = log.String(f.Key(), rv)
+		}
+	}
+	return out
+}
@@ -0,0 +1,250 @@
+// Package retry 为 gRPC 客户端提供重试和对冲请求：指数退避加抖动、可重试状态码、单次超时，
+// 每次尝试是独立的子 span，最终结果记录在父 span 上
+package retry
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// span 标签
+const (
+	TagAttempt  = "retry.attempt"
+	TagAttempts = "retry.attempts"
+	TagHedged   = "retry.hedged"
+	TagOutcome  = "retry.outcome"
+	TagCode     = "grpc.code"
+)
+
+// 记录在父 span 上的最终结果
+const (
+	OutcomeSuccess      = "success"
+	OutcomeNonRetryable = "non_retryable"
+	OutcomeExhausted    = "exhausted"
+	OutcomeDeadline     = "deadline"
+)
+
+// Policy 是 gRPC 调用的重试策略。HedgeDelay 大于 0 时启用对冲：
+// 上一次尝试超过 HedgeDelay 仍未返回就并发发起下一次，先成功的结果生效，其余的被取消。
+type Policy struct {
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	Multiplier        float64
+	Jitter            float64
+	RetryableCodes    []codes.Code
+	PerAttemptTimeout time.Duration
+	HedgeDelay        time.Duration
+}
+
+// DefaultPolicy 最多尝试 3 次，重试 Unavailable 和 ResourceExhausted
+var DefaultPolicy = Policy{
+	MaxAttempts:       3,
+	InitialBackoff:    50 * time.Millisecond,
+	MaxBackoff:        500 * time.Millisecond,
+	Multiplier:        2,
+	Jitter:            0.2,
+	RetryableCodes:    []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+	PerAttemptTimeout: 300 * time.Millisecond,
+}
+
+// retryable 判断 err 能否重试；调用方的上下文已经结束时不再重试
+func (p Policy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	code := status.Code(err)
+	if code == codes.DeadlineExceeded && p.PerAttemptTimeout > 0 {
+		return true
+	}
+	for _, c := range p.RetryableCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 返回第 attempt 次尝试失败后的等待时间，按 Jitter 比例随机缩短
+func (p Policy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d -= d * p.Jitter * rand.Float64()
+	return time.Duration(d)
+}
+
+type call struct {
+	policy  Policy
+	tracer  opentracing.Tracer
+	parent  opentracing.Span
+	method  string
+	cc      *grpc.ClientConn
+	invoker grpc.UnaryInvoker
+	opts    []grpc.CallOption
+}
+
+// attempt 在独立的子 span 中发起一次调用，子 span 放进上下文，内层拦截器会以它为父 span 注入
+func (c *call) attempt(ctx context.Context, n int, hedged bool, req, reply interface{}) error {
+	span := c.tracer.StartSpan(c.method,
+		opentracing.ChildOf(c.parent.Context()),
+		opentracing.Tag{Key: TagAttempt, Value: n},
+		opentracing.Tag{Key: TagHedged, Value: hedged},
+	)
+	defer span.Finish()
+
+	if c.policy.PerAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.policy.PerAttemptTimeout)
+		defer cancel()
+	}
+	err := c.invoker(opentracing.ContextWithSpan(ctx, span), c.method, req, reply, c.cc, c.opts...)
+	span.SetTag(TagCode, status.Code(err).String())
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	return err
+}
+
+// sleep 等待 d，上下文的截止时间早于 d 结束时直接返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sequential 依次重试，返回尝试次数和结果
+func (c *call) sequential(ctx context.Context, req, reply interface{}) (int, string, error) {
+	for n := 1; ; n++ {
+		err := c.attempt(ctx, n, false, req, reply)
+		switch {
+		case err == nil:
+			return n, OutcomeSuccess, nil
+		case !c.policy.retryable(ctx, err):
+			if ctx.Err() != nil {
+				return n, OutcomeDeadline, err
+			}
+			return n, OutcomeNonRetryable, err
+		case n >= c.policy.MaxAttempts:
+			return n, OutcomeExhausted, err
+		case !sleep(ctx, c.policy.backoff(n)):
+			return n, OutcomeDeadline, err
+		}
+	}
+}
+
+type result struct {
+	reply proto.Message
+	err   error
+}
+
+// hedged 每隔 HedgeDelay 或在可重试的失败之后并发发起新的尝试，第一个成功的结果写回 reply
+func (c *call) hedged(ctx context.Context, req interface{}, reply proto.Message) (int, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, c.policy.MaxAttempts)
+	launched, pending := 0, 0
+	launch := func() {
+		launched++
+		pending++
+		n, r := launched, proto.Clone(reply)
+		go func() {
+			results <- result{reply: r, err: c.attempt(ctx, n, n > 1, req, r)}
+		}()
+	}
+	timer := time.NewTimer(c.policy.HedgeDelay)
+	defer timer.Stop()
+
+	launch()
+	var lastErr error
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Reset(reply)
+				proto.Merge(reply, r.reply)
+				return launched, OutcomeSuccess, nil
+			}
+			lastErr = r.err
+			if !c.policy.retryable(ctx, r.err) {
+				return launched, OutcomeNonRetryable, r.err
+			}
+			if launched < c.policy.MaxAttempts {
+				launch()
+				timer.Reset(c.policy.HedgeDelay)
+			} else if pending == 0 {
+				return launched, OutcomeExhausted, lastErr
+			}
+		case <-timer.C:
+			if launched < c.policy.MaxAttempts {
+				launch()
+				timer.Reset(c.policy.HedgeDelay)
+			}
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = status.FromContextError(ctx.Err()).Err()
+			}
+			return launched, OutcomeDeadline, lastErr
+		}
+	}
+}
+
+// UnaryClientInterceptor 按 p 重试一元调用，需要放在注入追踪信息的拦截器之前。
+// 父 span 以上下文中的 span 为父节点，上下文的截止时间限制所有尝试和退避的总时长。
+func UnaryClientInterceptor(p Policy) grpc.UnaryClientInterceptor {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+		tracer := opentracing.GlobalTracer()
+		var parentOpts []opentracing.StartSpanOption
+		if sp := opentracing.SpanFromContext(ctx); sp != nil {
+			tracer = sp.Tracer()
+			parentOpts = append(parentOpts, opentracing.ChildOf(sp.Context()))
+		}
+		parent := tracer.StartSpan("retry "+method, parentOpts...)
+		defer parent.Finish()
+
+		c := &call{policy: p, tracer: tracer, parent: parent, method: method, cc: cc, invoker: invoker, opts: opts}
+		var (
+			attempts int
+			outcome  string
+			err      error
+		)
+		if m, ok := reply.(proto.Message); ok && p.HedgeDelay > 0 {
+			attempts, outcome, err = c.hedged(ctx, req, m)
+		} else {
+			attempts, outcome, err = c.sequential(ctx, req, reply)
+		}
+
+		parent.SetTag(TagAttempts, attempts)
+		parent.SetTag(TagOutcome, outcome)
+		parent.SetTag(TagCode, status.Code(err).String())
+		if err != nil {
+			ext.Error.Set(parent, true)
+		}
+		return err
+	}
+}
@@ -0,0 +1,185 @@
+package retry
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const method = "/helloworld.Greeter/SayHello"
+
+var testPolicy = Policy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryableCodes: []codes.Code{codes.Unavailable},
+}
+
+// invoke 用 mocktracer 调用拦截器，返回父 span 和按结束顺序排列的尝试 span
+func invoke(t *testing.T, p Policy, ctx context.Context, invoker grpc.UnaryInvoker) (*mocktracer.MockSpan, []*mocktracer.MockSpan, error) {
+	t.Helper()
+	tracer := mocktracer.New()
+	root := tracer.StartSpan("GET /api/product/:id")
+	ctx = opentracing.ContextWithSpan(ctx, root)
+
+	err := UnaryClientInterceptor(p)(ctx, method, wrapperspb.String("req"), wrapperspb.String(""), nil, invoker)
+	root.Finish()
+
+	var parent *mocktracer.MockSpan
+	var attempts []*mocktracer.MockSpan
+	for _, s := range tracer.FinishedSpans() {
+		switch s.OperationName {
+		case "retry " + method:
+			parent = s
+		case method:
+			attempts = append(attempts, s)
+		}
+	}
+	if parent == nil {
+		t.Fatal("parent span not finished")
+	}
+	for _, a := range attempts {
+		if a.ParentID != parent.SpanContext.SpanID {
+			t.Fatalf("attempt %v not a child of the retry span", a.Tags())
+		}
+	}
+	return parent, attempts, err
+}
+
+// failing 返回依次给出 errs 中错误的 invoker，之后都成功
+func failing(calls *int32, errs ...error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		n := int(atomic.AddInt32(calls, 1))
+		if opentracing.SpanFromContext(ctx) == nil {
+			return status.Error(codes.Internal, "attempt span missing from context")
+		}
+		if n <= len(errs) {
+			return errs[n-1]
+		}
+		reply.(*wrapperspb.StringValue).Value = "hello"
+		return nil
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	var calls int32
+	unavailable := status.Error(codes.Unavailable, "down")
+	parent, attempts, err := invoke(t, testPolicy, context.Background(), failing(&calls, unavailable, unavailable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempt spans, got %d", len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Tag(TagAttempt) != i+1 {
+			t.Fatalf("attempt %d tagged %v", i+1, a.Tag(TagAttempt))
+		}
+	}
+	if attempts[0].Tag("error") != true || attempts[2].Tag("error") != nil {
+		t.Fatal("attempt errors not recorded")
+	}
+	if parent.Tag(TagAttempts) != 3 || parent.Tag(TagOutcome) != OutcomeSuccess || parent.Tag("error") != nil {
+		t.Fatalf("unexpected parent tags %v", parent.Tags())
+	}
+}
+
+func TestRetryStops(t *testing.T) {
+	var calls int32
+	parent, _, err := invoke(t, testPolicy, context.Background(), failing(&calls, status.Error(codes.PermissionDenied, "no")))
+	if status.Code(err) != codes.PermissionDenied || calls != 1 {
+		t.Fatalf("non-retryable error retried: %v after %d calls", err, calls)
+	}
+	if parent.Tag(TagOutcome) != OutcomeNonRetryable || parent.Tag("error") != true {
+		t.Fatalf("unexpected parent tags %v", parent.Tags())
+	}
+
+	calls = 0
+	unavailable := status.Error(codes.Unavailable, "down")
+	parent, _, err = invoke(t, testPolicy, context.Background(), failing(&calls, unavailable, unavailable, unavailable, unavailable))
+	if status.Code(err) != codes.Unavailable || calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d: %v", calls, err)
+	}
+	if parent.Tag(TagOutcome) != OutcomeExhausted {
+		t.Fatalf("unexpected parent tags %v", parent.Tags())
+	}
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	p := testPolicy
+	p.MaxAttempts = 10
+	p.InitialBackoff = 50 * time.Millisecond
+	p.PerAttemptTimeout = 20 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+
+	var calls int32
+	slow := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&calls, 1)
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	start := time.Now()
+	parent, attempts, err := invoke(t, p, ctx, slow)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("retries ignored the deadline, took %v", elapsed)
+	}
+	if len(attempts) >= 10 || parent.Tag(TagOutcome) != OutcomeDeadline {
+		t.Fatalf("unexpected result: %d attempts, tags %v", len(attempts), parent.Tags())
+	}
+}
+
+func TestHedging(t *testing.T) {
+	p := testPolicy
+	p.HedgeDelay = 10 * time.Millisecond
+
+	var calls int32
+	hedged := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// 第一次尝试一直挂起，直到被取消
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		reply.(*wrapperspb.StringValue).Value = "hedged"
+		return nil
+	}
+
+	tracer := mocktracer.New()
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("root"))
+	reply := wrapperspb.String("")
+	if err := UnaryClientInterceptor(p)(ctx, method, wrapperspb.String("req"), reply, nil, hedged); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Value != "hedged" {
+		t.Fatalf("hedged reply not copied back: %q", reply.Value)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(tracer.FinishedSpans()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	var hedges int
+	for _, s := range tracer.FinishedSpans() {
+		if s.Tag(TagHedged) == true {
+			hedges++
+		}
+		if s.OperationName == "retry "+method && (s.Tag(TagAttempts) != 2 || s.Tag(TagOutcome) != OutcomeSuccess) {
+			t.Fatalf("unexpected parent tags %v", s.Tags())
+		}
+	}
+	if hedges != 1 {
+		t.Fatalf("expected one hedged attempt, got %d", hedges)
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_opentracing "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"opentracing-sample/config"
+	"opentracing-sample/fault"
+	"opentracing-sample/ratelimit"
+	"opentracing-sample/recovery"
+	"opentracing-sample/tenant"
+)
+
+// Server is used to implement helloworld.GreeterServer.
+type Server struct {
+	UnimplementedGreeterServer
+}
+
+// SayHello implements helloworld.GreeterServer
+func (s *Server) SayHello(ctx context.Context, in *HelloRequest) (*HelloReply, error) {
+	config.Log.WithFields(config.BaggageFields(ctx)).Infof("Received: %v", in.GetName())
+	return &HelloReply{Message: "Hello " + in.GetName()}, nil
+}
+
+type options struct {
+	limiter *ratelimit.Limiter
+	faults  *fault.Injector
+}
+
+// Option 配置 NewServer 创建的服务
+type Option func(*options)
+
+// WithLimiter 为服务挂上限流，默认不限流
+func WithLimiter(l *ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// WithFaults 为服务挂上故障注入，默认不注入
+func WithFaults(i *fault.Injector) Option {
+	return func(o *options) {
+		o.faults = i
+	}
+}
+
+// NewServer 创建注册了 Greeter 服务的 grpc.Server，并挂上 opentracing 拦截器
+func NewServer(tracer opentracing.Tracer, opts ...Option) *grpc.Server {
+	o := &options{limiter: ratelimit.New(ratelimit.Config{}), faults: fault.Disabled()}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s := grpc.NewServer(
+		grpc.StreamInterceptor(grpcMiddleware.ChainStreamServer(
+			config.BaggageStreamServerInterceptor(),
+			// add opentracing stream interceptor to chain
+			grpc_opentracing.StreamServerInterceptor(grpc_opentracing.WithTracer(tracer)),
+			recovery.StreamServerInterceptor(),
+			config.TagBaggageStreamServerInterceptor(),
+			config.CaptureStreamServerInterceptor(),
+			tenant.StreamServerInterceptor(tenant.TokenSecret),
+			ratelimit.StreamServerInterceptor(o.limiter),
+			fault.StreamServerInterceptor(o.faults),
+		)),
+		grpc.UnaryInterceptor(grpcMiddleware.ChainUnaryServer(
+			config.BaggageUnaryServerInterceptor(),
+			// add opentracing unary interceptor to chain
+			grpc_opentracing.UnaryServerInterceptor(grpc_opentracing.WithTracer(tracer)),
+			recovery.UnaryServerInterceptor(),
+			config.TagBaggageUnaryServerInterceptor(),
+			config.CaptureUnaryServerInterceptor(),
+			tenant.UnaryServerInterceptor(tenant.TokenSecret),
+			ratelimit.UnaryServerInterceptor(o.limiter),
+			fault.UnaryServerInterceptor(o.faults),
+		)),
+	)
+
+	RegisterGreeterServer(s, &Server{})
+	return s
+}
@@ -0,0 +1,47 @@
+// Package servicetest 提供在内存中运行 grpc-server 的测试工具，不需要真正监听端口
+package servicetest
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"opentracing-sample/service"
+)
+
+const bufSize = 1024 * 1024
+
+// Server 是跑在 bufconn 监听器上的 grpc-server
+type Server struct {
+	*grpc.Server
+	lis *bufconn.Listener
+}
+
+// NewServer 用 service.NewServer 构造服务并在 bufconn 上开始服务，用完需调用 Close
+func NewServer(tracer opentracing.Tracer, opts ...service.Option) *Server {
+	s := &Server{
+		Server: service.NewServer(tracer, opts...),
+		lis:    bufconn.Listen(bufSize),
+	}
+	go s.Serve(s.lis)
+	return s
+}
+
+// Dialer 返回连接到该内存服务的拨号函数，忽略传入的地址
+func (s *Server) Dialer() func(context.Context, string) (net.Conn, error) {
+	return func(context.Context, string) (net.Conn, error) {
+		return s.lis.Dial()
+	}
+}
+
+// DialOption 返回可直接传给 grpc.DialContext 的拨号选项
+func (s *Server) DialOption() grpc.DialOption {
+	return grpc.WithContextDialer(s.Dialer())
+}
+
+// Close 停止服务并关闭监听器
+func (s *Server) Close() {
+	s.Stop()
+	s.lis.Close()
+}
@@ -0,0 +1,59 @@
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Duration 在 JSON 中写作 "1.5s"、"6h" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadFile 读取 JSON 格式的 SLO 配置，未设置的字段使用默认值
+func LoadFile(path string) (Config, error) {
+	var cfg Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("slo: parse %s: %v", path, err)
+	}
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("slo: %v in %s", err, path)
+	}
+	return cfg, nil
+}
+
+// FromEnv 用 SLO_CONFIG 指向的文件创建 Tracker；未设置时没有任何目标
+func FromEnv() (*Tracker, error) {
+	path := os.Getenv("SLO_CONFIG")
+	if path == "" {
+		return New(Config{}), nil
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg), nil
+}
@@ -0,0 +1,20 @@
+package slo
+
+import (
+	"github.com/opentracing/opentracing-go/ext"
+	"opentracing-sample/spanhook"
+	"opentracing-sample/spanstore"
+)
+
+// Hook 返回交给 spanhook.NewTracer 的钩子，service 是与 Objective.Service 匹配的服务名。
+// 钩子在 tracer 中统计每个 span，不受采样影响。Tracker 作为 spanstore.Sink 接在 reporter 上时
+// 只能看到被采样的 span，达标率会随采样率和强制采样（如 anomaly 对慢请求的强制采样）偏移
+func (t *Tracker) Hook(service string) spanhook.Hook {
+	return func(f *spanhook.Finished) {
+		s := spanstore.Span{Service: service, Operation: f.Operation, Start: f.Start, Duration: f.Duration()}
+		if f.Error {
+			s.Tags = map[string]interface{}{string(ext.Error): true}
+		}
+		t.Consume(s)
+	}
+}
@@ -0,0 +1,303 @@
+// Package slo 从已结束的 span 计算服务等级目标（SLO）：在滚动窗口内统计达标的请求比例，
+// 并按多窗口燃烧率告警——长窗口和短窗口的错误预算消耗速度都超过阈值时，通过 config.Log 输出告警。
+// 燃烧率为 1 表示按这个速度窗口结束时预算恰好用完。结果通过 expvar 和 JSON 端点暴露。
+package slo
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"opentracing-sample/config"
+	"opentracing-sample/spanstore"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的统计窗口和时间桶大小，窗口内的数据按桶滚动淘汰
+const (
+	DefaultWindow = 24 * time.Hour
+	DefaultBucket = time.Minute
+)
+
+// checkInterval 是两次检查告警之间的最短间隔
+const checkInterval = time.Second
+
+// Metrics 按 SLO 名字暴露当前状态，通过 /debug/vars 查看
+var Metrics = expvar.NewMap("slo")
+
+// DefaultAlerts 是常用的两组多窗口告警：1 小时内用掉 2% 的 30 天预算，或 6 小时内用掉 5%
+var DefaultAlerts = []Alert{
+	{Long: Duration(time.Hour), Short: Duration(5 * time.Minute), Burn: 14.4},
+	{Long: Duration(6 * time.Hour), Short: Duration(30 * time.Minute), Burn: 6},
+}
+
+// Objective 是一个目标。Match 是 span 的操作名（gin 路由或 gRPC 方法全名），* 结尾表示前缀匹配；
+// Service 为空时匹配所有服务。Latency 为 0 时是可用性目标，没有 error 标签的 span 达标；
+// 否则还要求耗时不超过 Latency。Target 是达标比例，如 0.99。
+type Objective struct {
+	Name    string   `json:"name"`
+	Service string   `json:"service"`
+	Match   string   `json:"match"`
+	Target  float64  `json:"target"`
+	Latency Duration `json:"latency"`
+}
+
+func (o Objective) matches(s spanstore.Span) bool {
+	if o.Service != "" && o.Service != s.Service {
+		return false
+	}
+	if strings.HasSuffix(o.Match, "*") {
+		return strings.HasPrefix(s.Operation, strings.TrimSuffix(o.Match, "*"))
+	}
+	return o.Match == s.Operation
+}
+
+func (o Objective) good(s spanstore.Span) bool {
+	return !s.Error() && (o.Latency == 0 || s.Duration <= time.Duration(o.Latency))
+}
+
+// Alert 是一组多窗口告警，两个窗口的燃烧率都达到 Burn 时触发。短窗口让问题恢复后告警尽快解除
+type Alert struct {
+	Long  Duration `json:"long"`
+	Short Duration `json:"short"`
+	Burn  float64  `json:"burn"`
+}
+
+// String 是告警的名字，如 "1h0m0s/5m0s"
+func (a Alert) String() string {
+	return time.Duration(a.Long).String() + "/" + time.Duration(a.Short).String()
+}
+
+// Config 是 SLO 的完整配置。Window 是计算达标率和剩余预算的滚动窗口，Bucket 是统计的时间粒度
+type Config struct {
+	Window     Duration    `json:"window"`
+	Bucket     Duration    `json:"bucket"`
+	Objectives []Objective `json:"objectives"`
+	Alerts     []Alert     `json:"alerts"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Window <= 0 {
+		c.Window = Duration(DefaultWindow)
+	}
+	if c.Bucket <= 0 {
+		c.Bucket = Duration(DefaultBucket)
+	}
+	if c.Alerts == nil {
+		c.Alerts = DefaultAlerts
+	}
+	return c
+}
+
+func (c Config) validate() error {
+	if c.Bucket > c.Window {
+		return fmt.Errorf("bucket %s longer than window %s", time.Duration(c.Bucket), time.Duration(c.Window))
+	}
+	names := map[string]bool{}
+	for _, o := range c.Objectives {
+		if o.Name == "" || o.Match == "" || o.Target <= 0 || o.Target >= 1 || names[o.Name] {
+			return fmt.Errorf("invalid objective %+v", o)
+		}
+		names[o.Name] = true
+	}
+	for _, a := range c.Alerts {
+		if a.Burn <= 0 || a.Short <= 0 || a.Short > a.Long || a.Long > c.Window {
+			return fmt.Errorf("invalid alert %+v", a)
+		}
+	}
+	return nil
+}
+
+// Status 是一个目标的当前状态。Compliance 是窗口内的达标率，BudgetRemaining 是剩余的错误预算比例，
+// 超支时为负；BurnRates 按告警用到的窗口给出燃烧率，Firing 是正在触发的告警
+type Status struct {
+	Name            string             `json:"name"`
+	Target          float64            `json:"target"`
+	Latency         Duration           `json:"latency,omitempty"`
+	Window          Duration           `json:"window"`
+	Total           int64              `json:"total"`
+	Good            int64              `json:"good"`
+	Compliance      float64            `json:"compliance"`
+	BudgetRemaining float64            `json:"budget_remaining"`
+	BurnRates       map[string]float64 `json:"burn_rates"`
+	Firing          []string           `json:"firing"`
+}
+
+type bucket struct {
+	index       int64
+	good, total int64
+}
+
+// series 是一个目标的计数，buckets 是按时间桶编号取模的环形数组
+type series struct {
+	objective Objective
+	buckets   []bucket
+	firing    []bool
+}
+
+// Tracker 按配置的目标统计 span，通过 Hook 接入时统计所有 span。
+// 它也实现 spanstore.Sink，但接在 reporter 上时只统计被采样的 span，见 Hook
+type Tracker struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	series  []*series
+	checked time.Time
+}
+
+// New 创建 Tracker，并把每个目标的状态注册到 Metrics。cfg 应已通过 LoadFile 校验，未设置的字段使用默认值
+func New(cfg Config) *Tracker {
+	cfg = cfg.withDefaults()
+	t := &Tracker{cfg: cfg, now: time.Now}
+	n := int((cfg.Window + cfg.Bucket - 1) / cfg.Bucket)
+	for _, o := range cfg.Objectives {
+		se := &series{objective: o, buckets: make([]bucket, n), firing: make([]bool, len(cfg.Alerts))}
+		t.series = append(t.series, se)
+		Metrics.Set(o.Name, expvar.Func(func() interface{} {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			return t.status(se, t.now())
+		}))
+	}
+	return t
+}
+
+func (t *Tracker) index(at time.Time) int64 {
+	return at.UnixNano() / int64(t.cfg.Bucket)
+}
+
+// add 按 span 结束的时间计入对应的桶，已经滑出窗口的 span 不计
+func (t *Tracker) add(se *series, at time.Time, good bool, now time.Time) {
+	i := t.index(at)
+	if i <= t.index(now)-int64(len(se.buckets)) {
+		return
+	}
+	b := &se.buckets[i%int64(len(se.buckets))]
+	if b.index != i {
+		*b = bucket{index: i}
+	}
+	b.total++
+	if good {
+		b.good++
+	}
+}
+
+// sum 统计 now 之前 window 内的桶，包括当前未满的桶
+func (t *Tracker) sum(se *series, window time.Duration, now time.Time) (good, total int64) {
+	cur := t.index(now)
+	n := int64((window + time.Duration(t.cfg.Bucket) - 1) / time.Duration(t.cfg.Bucket))
+	if n > int64(len(se.buckets)) {
+		n = int64(len(se.buckets))
+	}
+	for i := cur; i > cur-n; i-- {
+		if b := se.buckets[i%int64(len(se.buckets))]; b.index == i {
+			good += b.good
+			total += b.total
+		}
+	}
+	return good, total
+}
+
+// burnRate 是窗口内的不达标比例与允许的不达标比例之比，没有请求时为 0
+func (t *Tracker) burnRate(se *series, window time.Duration, now time.Time) float64 {
+	good, total := t.sum(se, window, now)
+	if total == 0 {
+		return 0
+	}
+	return float64(total-good) / float64(total) / (1 - se.objective.Target)
+}
+
+func (t *Tracker) status(se *series, now time.Time) Status {
+	o := se.objective
+	good, total := t.sum(se, time.Duration(t.cfg.Window), now)
+	s := Status{
+		Name:            o.Name,
+		Target:          o.Target,
+		Latency:         o.Latency,
+		Window:          t.cfg.Window,
+		Total:           total,
+		Good:            good,
+		Compliance:      1,
+		BudgetRemaining: 1,
+		BurnRates:       map[string]float64{},
+		Firing:          []string{},
+	}
+	if total > 0 {
+		s.Compliance = float64(good) / float64(total)
+		s.BudgetRemaining = 1 - float64(total-good)/(float64(total)*(1-o.Target))
+	}
+	for i, a := range t.cfg.Alerts {
+		for _, w := range []Duration{a.Long, a.Short} {
+			s.BurnRates[time.Duration(w).String()] = t.burnRate(se, time.Duration(w), now)
+		}
+		if se.firing[i] {
+			s.Firing = append(s.Firing, a.String())
+		}
+	}
+	return s
+}
+
+func (t *Tracker) Consume(s spanstore.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, se := range t.series {
+		if se.objective.matches(s) {
+			t.add(se, s.End(), se.objective.good(s), now)
+		}
+	}
+	if now.Sub(t.checked) >= checkInterval {
+		t.check(now)
+	}
+}
+
+// check 计算每组告警的燃烧率，只在告警触发和解除时输出日志
+func (t *Tracker) check(now time.Time) {
+	t.checked = now
+	log := config.Log.WithField("component", "slo")
+	for _, se := range t.series {
+		for i, a := range t.cfg.Alerts {
+			long := t.burnRate(se, time.Duration(a.Long), now)
+			short := t.burnRate(se, time.Duration(a.Short), now)
+			firing := long >= a.Burn && short >= a.Burn
+			if firing == se.firing[i] {
+				continue
+			}
+			se.firing[i] = firing
+			entry := log.WithField("slo", se.objective.Name).WithField("alert", a.String())
+			if firing {
+				entry.Warnf("error budget burning too fast: %.1fx over %s, %.1fx over %s (threshold %.1fx)",
+					long, time.Duration(a.Long), short, time.Duration(a.Short), a.Burn)
+			} else {
+				entry.Infof("error budget burn back to normal: %.1fx over %s, %.1fx over %s",
+					long, time.Duration(a.Long), short, time.Duration(a.Short))
+			}
+		}
+	}
+}
+
+// Statuses 返回所有目标的当前状态，按名字排序。没有新的 span 时告警也在这里解除
+func (t *Tracker) Statuses() []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if now.Sub(t.checked) >= checkInterval {
+		t.check(now)
+	}
+	statuses := make([]Status, 0, len(t.series))
+	for _, se := range t.series {
+		statuses = append(statuses, t.status(se, now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// ServeHTTP 以 JSON 输出所有目标的当前状态
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Statuses())
+}
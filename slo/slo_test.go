@@ -0,0 +1,202 @@
+package slo
+
+import (
+	"encoding/json"
+	"expvar"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/uber/jaeger-client-go"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"opentracing-sample/config"
+	"opentracing-sample/spanhook"
+	"opentracing-sample/spanstore"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func span(service, op string, end time.Time, d time.Duration, failed bool) spanstore.Span {
+	s := spanstore.Span{Service: service, Operation: op, Start: end.Add(-d), Duration: d}
+	if failed {
+		s.Tags = map[string]interface{}{"error": true}
+	}
+	return s
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func newTracker(cfg Config, now *time.Time) *Tracker {
+	tr := New(cfg)
+	tr.now = func() time.Time { return *now }
+	return tr
+}
+
+var objectives = []Objective{
+	{Name: "product-latency", Service: "gin-sample", Match: "/api/product*", Target: 0.99, Latency: Duration(1500 * time.Millisecond)},
+	{Name: "product-availability", Service: "gin-sample", Match: "/api/product*", Target: 0.999},
+}
+
+func TestBurnRates(t *testing.T) {
+	now := t0
+	tr := newTracker(Config{Objectives: objectives}, &now)
+
+	// 2 小时前 900 个正常请求，最近 1 分钟 100 个请求中 20 个超过 1.5 秒、1 个出错
+	for i := 0; i < 900; i++ {
+		tr.Consume(span("gin-sample", "/api/product/:id", now.Add(-2*time.Hour), 100*time.Millisecond, false))
+	}
+	for i := 0; i < 100; i++ {
+		d := 100 * time.Millisecond
+		if i < 20 {
+			d = 2 * time.Second
+		}
+		tr.Consume(span("gin-sample", "/api/product", now.Add(-30*time.Second), d, i == 99))
+	}
+	// 不匹配的服务、操作和滑出窗口的 span 不计
+	tr.Consume(span("auth-api", "/api/product", now, 5*time.Second, true))
+	tr.Consume(span("gin-sample", "/debug/vars", now, 5*time.Second, true))
+	tr.Consume(span("gin-sample", "/api/product", now.Add(-25*time.Hour), 5*time.Second, true))
+
+	// 告警每秒最多检查一次
+	now = now.Add(time.Second)
+	statuses := tr.Statuses()
+	availability, latency := statuses[0], statuses[1]
+	if latency.Name != "product-latency" || latency.Total != 1000 || latency.Good != 979 {
+		t.Fatalf("unexpected latency status %+v", latency)
+	}
+	// 21 个不达标（20 个慢、1 个出错），预算是 1000 * 1% = 10
+	if !near(latency.Compliance, 0.979) || !near(latency.BudgetRemaining, 1-2.1) {
+		t.Errorf("unexpected compliance %+v", latency)
+	}
+	// 最近 1 小时和 5 分钟都是 21/100，燃烧率 21；6 小时内 21/1000，燃烧率 2.1
+	if !near(latency.BurnRates["5m0s"], 21) || !near(latency.BurnRates["1h0m0s"], 21) ||
+		!near(latency.BurnRates["30m0s"], 21) || !near(latency.BurnRates["6h0m0s"], 2.1) {
+		t.Errorf("unexpected burn rates %v", latency.BurnRates)
+	}
+	if len(latency.Firing) != 1 || latency.Firing[0] != "1h0m0s/5m0s" {
+		t.Errorf("expected only the fast burn alert, got %v", latency.Firing)
+	}
+
+	if availability.Total != 1000 || availability.Good != 999 || !near(availability.BudgetRemaining, 0) {
+		t.Errorf("unexpected availability status %+v", availability)
+	}
+	if !near(availability.BurnRates["5m0s"], 10) || !near(availability.BurnRates["6h0m0s"], 1) || len(availability.Firing) != 0 {
+		t.Errorf("unexpected availability burn rates %v %v", availability.BurnRates, availability.Firing)
+	}
+
+	var fromVars Status
+	if err := json.Unmarshal([]byte(expvar.Get("slo").(*expvar.Map).Get("product-latency").String()), &fromVars); err != nil {
+		t.Fatal(err)
+	}
+	if fromVars.Total != 1000 || len(fromVars.Firing) != 1 {
+		t.Errorf("unexpected expvar status %+v", fromVars)
+	}
+
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/slo", nil))
+	var served []Status
+	if err := json.NewDecoder(rec.Body).Decode(&served); err != nil || len(served) != 2 || served[1].Latency != Duration(1500*time.Millisecond) {
+		t.Fatalf("unexpected response %+v, %v", served, err)
+	}
+}
+
+func TestAlertLogs(t *testing.T) {
+	hook := test.NewLocal(config.Log)
+	defer hook.Reset()
+
+	now := t0
+	tr := newTracker(Config{Objectives: objectives[1:]}, &now)
+	for i := 0; i < 10; i++ {
+		tr.Consume(span("gin-sample", "/api/product", now, time.Millisecond, i < 2))
+	}
+	var warnings []*logrus.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Data["component"] == "slo" && e.Level == logrus.WarnLevel {
+			warnings = append(warnings, e)
+		}
+	}
+	// 20% 出错，燃烧率 200，两组告警都触发，各只输出一次
+	if len(warnings) != 2 || warnings[0].Data["slo"] != "product-availability" {
+		t.Fatalf("unexpected warnings %v", hook.AllEntries())
+	}
+
+	// 10 分钟后出错的请求滑出 5 分钟的短窗口，快速告警解除；仍在 30 分钟窗口内，慢速告警继续触发
+	hook.Reset()
+	now = now.Add(10 * time.Minute)
+	tr.Consume(span("gin-sample", "/api/product", now, time.Millisecond, false))
+	entries := hook.AllEntries()
+	if len(entries) != 1 || entries[0].Level != logrus.InfoLevel || entries[0].Data["alert"] != "1h0m0s/5m0s" {
+		t.Fatalf("expected the fast burn alert to resolve, got %v", entries)
+	}
+	if firing := tr.Statuses()[0].Firing; len(firing) != 1 || firing[0] != "6h0m0s/30m0s" {
+		t.Fatalf("unexpected firing alerts %v", firing)
+	}
+}
+
+func TestHookCountsUnsampledSpans(t *testing.T) {
+	now := t0
+	tr := newTracker(Config{Objectives: objectives}, &now)
+	reporter := jaeger.NewInMemoryReporter()
+	inner, closer := jaeger.NewTracer("gin-sample", jaeger.NewConstSampler(false), reporter)
+	defer closer.Close()
+	tracer := spanhook.NewTracer(inner, tr.Hook("gin-sample"))
+
+	for i := 0; i < 4; i++ {
+		span := tracer.StartSpan("GET", opentracing.StartTime(now.Add(-time.Second)))
+		span.SetOperationName("/api/product/:id")
+		if i == 0 {
+			ext.Error.Set(span, true)
+		}
+		span.FinishWithOptions(opentracing.FinishOptions{FinishTime: now})
+	}
+	tracer.StartSpan("/api/product", opentracing.StartTime(now.Add(-2*time.Second))).
+		FinishWithOptions(opentracing.FinishOptions{FinishTime: now})
+
+	if n := len(reporter.GetSpans()); n != 0 {
+		t.Fatalf("expected no sampled spans, got %d", n)
+	}
+	// 5 个 span 都没有被采样，仍然全部计入；1 个出错、1 个超过 1.5 秒
+	statuses := tr.Statuses()
+	if statuses[0].Total != 5 || statuses[0].Good != 4 || statuses[1].Total != 5 || statuses[1].Good != 3 {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "slo.json")
+
+	ioutil.WriteFile(path, []byte(`{"window":"168h","objectives":[
+		{"name":"product-latency","match":"/api/product*","target":0.99,"latency":"1.5s"}]}`), 0644)
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Window != Duration(168*time.Hour) || cfg.Bucket != Duration(DefaultBucket) || len(cfg.Alerts) != 2 ||
+		cfg.Objectives[0].Latency != Duration(1500*time.Millisecond) {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	for _, content := range []string{
+		`{"objectives":[{"name":"a","match":"/","target":99}]}`,
+		`{"objectives":[{"name":"a","match":"/","target":0.9},{"name":"a","match":"/","target":0.9}]}`,
+		`{"window":"1h","alerts":[{"long":"6h","short":"30m","burn":6}]}`,
+	} {
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadFile(path); err == nil {
+			t.Errorf("%s should be rejected", content)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Package spanhook 包装 opentracing.Tracer，每个 span 结束之前依次调用注册的钩子。
+// 钩子在 tracer 中执行，未被采样的 span 也会经过，适合需要看到全部请求的统计（SLO、异常检测）；
+// 只需要处理上报内容的逻辑应放在 reporter 装饰器中。
+package spanhook
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"time"
+)
+
+// Finished 是正在结束的 span。Span 是底层的 span，钩子可以在它真正结束之前设置标签
+type Finished struct {
+	Span      opentracing.Span
+	Operation string
+	Start     time.Time
+	Finish    time.Time
+	// Error 表示 span 标记了 error
+	Error bool
+}
+
+// Duration 返回 span 的耗时
+func (f *Finished) Duration() time.Duration {
+	return f.Finish.Sub(f.Start)
+}
+
+// Hook 在 span 结束之前调用，在 Finish 的调用方 goroutine 中执行，需要尽快返回
+type Hook func(f *Finished)
+
+// Tracer 包装 opentracing.Tracer，记录 span 的操作名、开始时间和 error 标签，结束时交给钩子
+type Tracer struct {
+	opentracing.Tracer
+	hooks []Hook
+}
+
+// NewTracer 包装 tracer，hooks 按顺序调用
+func NewTracer(tracer opentracing.Tracer, hooks ...Hook) *Tracer {
+	return &Tracer{Tracer: tracer, hooks: hooks}
+}
+
+func (t *Tracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var sso opentracing.StartSpanOptions
+	for _, o := range opts {
+		o.Apply(&sso)
+	}
+	start := sso.StartTime
+	if start.IsZero() {
+		start = time.Now()
+		opts = append(opts, opentracing.StartTime(start))
+	}
+	return &span{
+		Span:      t.Tracer.StartSpan(operationName, opts...),
+		tracer:    t,
+		operation: operationName,
+		start:     start,
+		failed:    sso.Tags[string(ext.Error)] == true,
+	}
+}
+
+type span struct {
+	opentracing.Span
+	tracer    *Tracer
+	operation string
+	start     time.Time
+	failed    bool
+}
+
+func (s *span) SetTag(key string, value interface{}) opentracing.Span {
+	if key == string(ext.Error) {
+		s.failed = value == true
+	}
+	s.Span.SetTag(key, value)
+	return s
+}
+
+func (s *span) SetOperationName(operationName string) opentracing.Span {
+	s.operation = operationName
+	s.Span.SetOperationName(operationName)
+	return s
+}
+
+func (s *span) SetBaggageItem(key, value string) opentracing.Span {
+	s.Span.SetBaggageItem(key, value)
+	return s
+}
+
+func (s *span) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+func (s *span) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{})
+}
+
+func (s *span) FinishWithOptions(opts opentracing.FinishOptions) {
+	if opts.FinishTime.IsZero() {
+		opts.FinishTime = time.Now()
+	}
+	f := &Finished{Span: s.Span, Operation: s.operation, Start: s.start, Finish: opts.FinishTime, Error: s.failed}
+	for _, hook := range s.tracer.hooks {
+		hook(f)
+	}
+	s.Span.FinishWithOptions(opts)
+}
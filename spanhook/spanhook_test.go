@@ -0,0 +1,39 @@
+package spanhook
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	mt := mocktracer.New()
+	var finished []Finished
+	tracer := NewTracer(mt,
+		func(f *Finished) { finished = append(finished, *f) },
+		func(f *Finished) { f.Span.SetTag("hooked", true) },
+	)
+
+	start := time.Unix(100, 0)
+	span := tracer.StartSpan("GET", opentracing.StartTime(start))
+	span.SetOperationName("/api/product/:id")
+	ext.Error.Set(span, true)
+	span.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(time.Second)})
+
+	if span.Tracer() != tracer {
+		t.Fatal("span.Tracer() should return the wrapper")
+	}
+	if len(finished) != 1 {
+		t.Fatalf("expected one hooked span, got %+v", finished)
+	}
+	f := finished[0]
+	if f.Operation != "/api/product/:id" || !f.Start.Equal(start) || f.Duration() != time.Second || !f.Error {
+		t.Fatalf("unexpected finished span %+v", f)
+	}
+	spans := mt.FinishedSpans()
+	if len(spans) != 1 || spans[0].Tag("hooked") != true {
+		t.Fatalf("tag set by the hook not recorded: %v", spans)
+	}
+}
@@ -0,0 +1,110 @@
+package spanstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// File 把每个 span 作为一行 JSON 追加到文件中，Read 可以读回
+type File struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	err error
+}
+
+// OpenFile 以追加方式打开 path，用完需要 Close
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Consume 写入一行，写失败后不再写入，错误由 Close 返回
+func (f *File) Consume(s Span) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = f.enc.Encode(s)
+	}
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.f.Close(); f.err == nil {
+		f.err = err
+	}
+	return f.err
+}
+
+// Read 读取 span，同时支持 Store 输出的 JSON 数组和 File 写出的每行一个 JSON
+func Read(r io.Reader) ([]Span, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	dec := json.NewDecoder(br)
+	if b, _ := br.Peek(1); b[0] == '[' {
+		var spans []Span
+		err := dec.Decode(&spans)
+		return spans, err
+	}
+	var spans []Span
+	for {
+		var s Span
+		err := dec.Decode(&s)
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			return spans, err
+		}
+		spans = append(spans, s)
+	}
+}
+
+// ReadFile 用 Read 读取文件
+func ReadFile(path string) ([]Span, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Load 读取文件，src 是 http 或 https 地址时从调试端点（如 /debug/spans）获取
+func Load(src string) ([]Span, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return ReadFile(src)
+	}
+	resp, err := http.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return Read(resp.Body)
+}
@@ -0,0 +1,28 @@
+package spanstore
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Percentile 按最近秩法取已排序样本的 p 分位，p 取 0 到 1，没有样本时返回 0
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(float64(len(sorted))*p)) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// SortDurations 原地排序并返回 ds，便于直接传给 Percentile
+func SortDurations(ds []time.Duration) []time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds
+}
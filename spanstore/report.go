@@ -0,0 +1,18 @@
+package spanstore
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Millis 以毫秒显示耗时，保留一位小数
+func Millis(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+// NewTable 返回命令行工具输出报告用的表格，各列以两个空格分隔，写完后需要调用 Flush
+func NewTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
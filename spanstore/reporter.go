@@ -0,0 +1,35 @@
+package spanstore
+
+import "github.com/uber/jaeger-client-go"
+
+// Sink 消费已结束的 span，Consume 在 span.Finish 的调用方 goroutine 中执行，需要尽快返回
+type Sink interface {
+	Consume(s Span)
+}
+
+// Reporter 是 jaeger.Reporter 的装饰器，把每个上报的 span 交给 sinks 后再交给 next
+type Reporter struct {
+	next  jaeger.Reporter
+	sinks []Sink
+}
+
+// NewReporter 创建装饰器，next 为 nil 时只交给 sinks
+func NewReporter(next jaeger.Reporter, sinks ...Sink) *Reporter {
+	return &Reporter{next: next, sinks: sinks}
+}
+
+func (r *Reporter) Report(s *jaeger.Span) {
+	rec := FromJaeger(s)
+	for _, sink := range r.sinks {
+		sink.Consume(rec)
+	}
+	if r.next != nil {
+		r.next.Report(s)
+	}
+}
+
+func (r *Reporter) Close() {
+	if r.next != nil {
+		r.next.Close()
+	}
+}
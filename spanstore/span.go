@@ -0,0 +1,88 @@
+// Package spanstore 把 jaeger 已结束的 span 转成与 tracer 无关的 JSON 记录，
+// 通过 reporter 装饰器交给各个消费方（调试存储、依赖图等），不影响原有的上报。
+package spanstore
+
+import (
+	"fmt"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"time"
+)
+
+// Span 是一个已结束 span 的记录，ID 使用 jaeger 的十六进制表示，
+// FollowsFrom 表示与父 span 是 FollowsFrom 关系，父 span 不等待它结束
+type Span struct {
+	TraceID     string                 `json:"trace_id"`
+	SpanID      string                 `json:"span_id"`
+	ParentID    string                 `json:"parent_id,omitempty"`
+	FollowsFrom bool                   `json:"follows_from,omitempty"`
+	Service     string                 `json:"service"`
+	Operation   string                 `json:"operation"`
+	Start       time.Time              `json:"start"`
+	Duration    time.Duration          `json:"duration"`
+	Tags        map[string]interface{} `json:"tags,omitempty"`
+}
+
+// FromJaeger 转换 jaeger 的 span，标签值中不是基本类型的（如 ext.SpanKindEnum）转成字符串
+func FromJaeger(s *jaeger.Span) Span {
+	sc := s.SpanContext()
+	rec := Span{
+		TraceID:   sc.TraceID().String(),
+		SpanID:    sc.SpanID().String(),
+		Service:   jaeger.BuildJaegerProcessThrift(s).ServiceName,
+		Operation: s.OperationName(),
+		Start:     s.StartTime(),
+		Duration:  s.Duration(),
+	}
+	if sc.ParentID() != 0 {
+		rec.ParentID = sc.ParentID().String()
+	}
+	// jaeger 优先以 ChildOf 引用作为父 span，只有 FollowsFrom 引用时父 span 才是 FollowsFrom 关系
+	for _, ref := range s.References() {
+		if ref.Type == opentracing.ChildOfRef {
+			rec.FollowsFrom = false
+			break
+		}
+		rec.FollowsFrom = true
+	}
+	if tags := s.Tags(); len(tags) > 0 {
+		rec.Tags = make(map[string]interface{}, len(tags))
+		for k, v := range tags {
+			rec.Tags[k] = tagValue(v)
+		}
+	}
+	return rec
+}
+
+func tagValue(v interface{}) interface{} {
+	switch v.(type) {
+	case bool, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// End 返回 span 结束的时间
+func (s Span) End() time.Time {
+	return s.Start.Add(s.Duration)
+}
+
+// Kind 返回 span.kind 标签，如 client、server、producer、consumer
+func (s Span) Kind() string {
+	return s.Tag(string(ext.SpanKind))
+}
+
+// Error 判断 span 是否标记了 error
+func (s Span) Error() bool {
+	return s.Tags[string(ext.Error)] == true
+}
+
+// Tag 以字符串返回标签值，不存在时返回空串
+func (s Span) Tag(key string) string {
+	v, ok := s.Tags[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
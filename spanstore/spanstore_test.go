@@ -0,0 +1,105 @@
+package spanstore
+
+import (
+	"encoding/json"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReporter(t *testing.T) {
+	store := NewStore(10)
+	inner := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("gin-sample-tracing", jaeger.NewConstSampler(true), NewReporter(inner, store))
+	defer closer.Close()
+
+	root := tracer.StartSpan("/api/product/:id", ext.SpanKindRPCServer)
+	child := tracer.StartSpan("call gRPC", opentracing.ChildOf(root.Context()), ext.SpanKindRPCClient)
+	ext.Error.Set(child, true)
+	child.Finish()
+	root.Finish()
+	job := tracer.StartSpan("job recomputeRating", opentracing.FollowsFrom(root.Context()))
+	job.Finish()
+
+	if inner.SpansSubmitted() != 3 {
+		t.Fatal("spans not passed on to the wrapped reporter")
+	}
+	spans := store.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %+v", spans)
+	}
+	c, r, j := spans[0], spans[1], spans[2]
+	if c.Service != "gin-sample-tracing" || c.Operation != "call gRPC" || c.Kind() != "client" || !c.Error() {
+		t.Errorf("unexpected client span %+v", c)
+	}
+	if c.ParentID != r.SpanID || c.TraceID != r.TraceID || r.ParentID != "" || c.FollowsFrom {
+		t.Errorf("parent not recorded: %+v %+v", c, r)
+	}
+	if !j.FollowsFrom || j.ParentID != r.SpanID {
+		t.Errorf("follows-from not recorded: %+v", j)
+	}
+	if c.End().Before(c.Start) || r.Duration < c.Duration {
+		t.Errorf("unexpected timing %+v %+v", c, r)
+	}
+
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/spans?trace_id="+j.TraceID, nil))
+	var got []Span
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Kind() != "client" || got[0].Duration != c.Duration {
+		t.Fatalf("JSON round trip lost data: %+v", got)
+	}
+}
+
+func TestStoreWrapsAround(t *testing.T) {
+	store := NewStore(2)
+	for _, id := range []string{"1", "2", "3"} {
+		store.Consume(Span{TraceID: "t", SpanID: id})
+	}
+	spans := store.Spans()
+	if len(spans) != 2 || spans[0].SpanID != "2" || spans[1].SpanID != "3" {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	if len(store.Trace("other")) != 0 || len(store.Trace("t")) != 2 {
+		t.Fatal("trace filter broken")
+	}
+}
+
+func TestFileAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Consume(Span{TraceID: "t", SpanID: "1", Duration: time.Millisecond})
+	f.Consume(Span{TraceID: "t", SpanID: "2", ParentID: "1"})
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	spans, err := ReadFile(path)
+	if err != nil || len(spans) != 2 || spans[0].Duration != time.Millisecond || spans[1].ParentID != "1" {
+		t.Fatalf("unexpected spans %+v, %v", spans, err)
+	}
+
+	spans, err = Read(strings.NewReader("\n [{\"trace_id\":\"t\",\"span_id\":\"1\"}]"))
+	if err != nil || len(spans) != 1 || spans[0].SpanID != "1" {
+		t.Fatalf("JSON array not read: %+v, %v", spans, err)
+	}
+	if spans, err := Read(strings.NewReader("")); err != nil || spans != nil {
+		t.Fatalf("empty input: %+v, %v", spans, err)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	ds := SortDurations([]time.Duration{5, 1, 4, 2, 3})
+	if Percentile(ds, 0.5) != 3 || Percentile(ds, 0.99) != 5 || Percentile(ds, 0) != 1 || Percentile(nil, 0.5) != 0 {
+		t.Fatalf("unexpected percentiles for %v", ds)
+	}
+}
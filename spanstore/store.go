@@ -0,0 +1,67 @@
+package spanstore
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Store 在内存中保留最近的 span，容量满后覆盖最早的，用作调试端点
+type Store struct {
+	mu    sync.RWMutex
+	spans []Span
+	next  int
+	full  bool
+}
+
+// NewStore 创建最多保留 capacity 个 span 的存储
+func NewStore(capacity int) *Store {
+	return &Store{spans: make([]Span, capacity)}
+}
+
+func (st *Store) Consume(s Span) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.spans) == 0 {
+		return
+	}
+	st.spans[st.next] = s
+	st.next = (st.next + 1) % len(st.spans)
+	if st.next == 0 {
+		st.full = true
+	}
+}
+
+// Spans 按上报顺序返回保留的 span
+func (st *Store) Spans() []Span {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if !st.full {
+		return append([]Span{}, st.spans[:st.next]...)
+	}
+	return append(append([]Span{}, st.spans[st.next:]...), st.spans[:st.next]...)
+}
+
+// Trace 返回属于 traceID 的 span
+func (st *Store) Trace(traceID string) []Span {
+	var spans []Span
+	for _, s := range st.Spans() {
+		if s.TraceID == traceID {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// ServeHTTP 以 JSON 数组输出保留的 span，带 trace_id 参数时只输出该 trace
+func (st *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	spans := st.Spans()
+	if id := r.URL.Query().Get("trace_id"); id != "" {
+		spans = st.Trace(id)
+	}
+	if spans == nil {
+		spans = []Span{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spans)
+}
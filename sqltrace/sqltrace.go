@@ -0,0 +1,448 @@
+// Package sqltrace 包装 database/sql 驱动，为 Query/Exec/Prepare 和事务创建客户端 span
+package sqltrace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"io"
+	"opentracing-sample/breaker"
+	"regexp"
+	"time"
+)
+
+const component = "database/sql"
+
+var literalRe = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"|\b\d+(?:\.\d+)?\b`)
+
+// RedactStatement 把 SQL 中的字符串和数字字面量替换成 ?，避免参数值进入 span
+func RedactStatement(query string) string {
+	return literalRe.ReplaceAllString(query, "?")
+}
+
+type config struct {
+	tracer   func() opentracing.Tracer
+	dbType   string
+	instance string
+	breaker  *breaker.Breaker
+}
+
+// Option 配置被包装的驱动
+type Option func(*config)
+
+// WithTracer 指定 tracer，默认使用 opentracing.GlobalTracer()
+func WithTracer(tracer opentracing.Tracer) Option {
+	return func(c *config) {
+		c.tracer = func() opentracing.Tracer { return tracer }
+	}
+}
+
+// WithDBType 设置 db.type 标签，如 mysql、sqlite
+func WithDBType(dbType string) Option {
+	return func(c *config) {
+		c.dbType = dbType
+	}
+}
+
+// WithInstance 设置 db.instance 标签，通常是库名
+func WithInstance(instance string) Option {
+	return func(c *config) {
+		c.instance = instance
+	}
+}
+
+// WithBreaker 让连接和语句经过熔断器，打开时直接返回错误而不访问数据库
+func WithBreaker(b *breaker.Breaker) Option {
+	return func(c *config) {
+		c.breaker = b
+	}
+}
+
+// Wrap 返回带追踪的驱动
+func Wrap(d driver.Driver, opts ...Option) driver.Driver {
+	c := &config{tracer: opentracing.GlobalTracer, dbType: "sql"}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return &tracedDriver{parent: d, cfg: c}
+}
+
+// Open 以 driverName 对应驱动的追踪版本打开数据库
+func Open(driverName, dsn string, opts ...Option) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close()
+
+	opts = append([]Option{WithDBType(driverName)}, opts...)
+	return sql.OpenDB(&connector{dsn: dsn, driver: Wrap(d, opts...).(*tracedDriver)}), nil
+}
+
+type connector struct {
+	dsn    string
+	driver *tracedDriver
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	done, err := c.driver.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.driver.Open(c.dsn)
+	done(err)
+	return conn, err
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+type tracedDriver struct {
+	parent driver.Driver
+	cfg    *config
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{parent: conn, cfg: d.cfg}, nil
+}
+
+// startSpan 只在上下文里已有 span 时创建子 span，避免连接池后台操作产生孤立的根 span
+func (c *config) startSpan(ctx context.Context, operation, query string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	span := c.tracer().StartSpan(operation, append([]opentracing.StartSpanOption{
+		opentracing.ChildOf(parent.Context()),
+		opentracing.Tag{Key: string(ext.Component), Value: component},
+		ext.SpanKindRPCClient,
+	}, opts...)...)
+	ext.DBType.Set(span, c.dbType)
+	if c.instance != "" {
+		ext.DBInstance.Set(span, c.instance)
+	}
+	if query != "" {
+		ext.DBStatement.Set(span, RedactStatement(query))
+	}
+	return span
+}
+
+// allow 在配置了熔断器时检查能否访问数据库，返回的 done 需要以操作结果调用
+func (c *config) allow(ctx context.Context) (func(error), error) {
+	if c.breaker == nil {
+		return func(error) {}, nil
+	}
+	done, err := c.breaker.Allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(err error) {
+		switch err {
+		case driver.ErrSkip:
+			err = breaker.ErrSkipped
+		case io.EOF:
+			err = nil
+		}
+		done(err)
+	}, nil
+}
+
+func finish(span opentracing.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && err != driver.ErrSkip && err != io.EOF {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	span.Finish()
+}
+
+type tracedConn struct {
+	parent driver.Conn
+	cfg    *config
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	done, err := c.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	span := c.cfg.startSpan(ctx, "sql.prepare", query)
+	var stmt driver.Stmt
+	if p, ok := c.parent.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.parent.Prepare(query)
+	}
+	done(err)
+	finish(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{parent: stmt, query: query, cfg: c.cfg}, nil
+}
+
+func (c *tracedConn) Close() error {
+	return c.parent.Close()
+}
+
+func (c *tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	done, err := c.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	span := c.cfg.startSpan(ctx, "sql.tx", "")
+	var tx driver.Tx
+	if b, ok := c.parent.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.parent.Begin()
+	}
+	done(err)
+	if err != nil {
+		finish(span, err)
+		return nil, err
+	}
+	return &tracedTx{parent: tx, span: span}, nil
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.parent.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done, err := c.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 驱动返回 ErrSkip 时 database/sql 会改走 Prepare，这次调用不产生 span。
+	// span 在调用之后才创建，开始时间取调用之前
+	start := time.Now()
+	res, err := execer.ExecContext(ctx, query, args)
+	done(err)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	span := c.cfg.startSpan(ctx, "sql.exec", query, opentracing.StartTime(start))
+	tagResult(span, res, err)
+	finish(span, err)
+	return res, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.parent.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done, err := c.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	done(err)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	span := c.cfg.startSpan(ctx, "sql.query", query, opentracing.StartTime(start))
+	if err != nil {
+		finish(span, err)
+		return nil, err
+	}
+	return &tracedRows{parent: rows, span: span}, nil
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if p, ok := c.parent.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.parent.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.parent.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func tagResult(span opentracing.Span, res driver.Result, err error) {
+	if span == nil || err != nil || res == nil {
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		span.SetTag("db.rows_affected", n)
+	}
+}
+
+type tracedStmt struct {
+	parent driver.Stmt
+	query  string
+	cfg    *config
+}
+
+func (s *tracedStmt) Close() error {
+	return s.parent.Close()
+}
+
+func (s *tracedStmt) NumInput() int {
+	return s.parent.NumInput()
+}
+
+func (s *tracedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.parent.Exec(args)
+}
+
+func (s *tracedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.parent.Query(args)
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	done, err := s.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var res driver.Result
+	if e, ok := s.parent.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedToValues(args); err == nil {
+			res, err = s.parent.Exec(values)
+		}
+	}
+	done(err)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	span := s.cfg.startSpan(ctx, "sql.exec", s.query, opentracing.StartTime(start))
+	tagResult(span, res, err)
+	finish(span, err)
+	return res, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	done, err := s.cfg.allow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var rows driver.Rows
+	if q, ok := s.parent.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedToValues(args); err == nil {
+			rows, err = s.parent.Query(values)
+		}
+	}
+	done(err)
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	span := s.cfg.startSpan(ctx, "sql.query", s.query, opentracing.StartTime(start))
+	if err != nil {
+		finish(span, err)
+		return nil, err
+	}
+	return &tracedRows{parent: rows, span: span}, nil
+}
+
+func namedToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}
+
+// tracedRows 在 Close 时结束 span，并记录读取的行数
+type tracedRows struct {
+	parent driver.Rows
+	span   opentracing.Span
+	count  int
+	err    error
+}
+
+func (r *tracedRows) Columns() []string {
+	return r.parent.Columns()
+}
+
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.parent.Next(dest)
+	if err == nil {
+		r.count++
+	} else if err != io.EOF {
+		r.err = err
+	}
+	return err
+}
+
+// Close 返回底层 Rows.Close 的错误，读取过程中的错误只记录在 span 上
+func (r *tracedRows) Close() error {
+	err := r.parent.Close()
+	if r.span != nil {
+		r.span.SetTag("db.rows", r.count)
+		spanErr := err
+		if spanErr == nil {
+			spanErr = r.err
+		}
+		finish(r.span, spanErr)
+		r.span = nil
+	}
+	return err
+}
+
+type tracedTx struct {
+	parent driver.Tx
+	span   opentracing.Span
+}
+
+func (t *tracedTx) Commit() error {
+	err := t.parent.Commit()
+	t.end("commit", err)
+	return err
+}
+
+func (t *tracedTx) Rollback() error {
+	err := t.parent.Rollback()
+	t.end("rollback", err)
+	return err
+}
+
+func (t *tracedTx) end(outcome string, err error) {
+	if t.span == nil {
+		return
+	}
+	t.span.SetTag("db.tx.outcome", outcome)
+	finish(t.span, err)
+	t.span = nil
+}
@@ -0,0 +1,181 @@
+package sqltrace
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"io"
+	_ "modernc.org/sqlite"
+	"opentracing-sample/breaker"
+	"testing"
+	"time"
+)
+
+func TestRedactStatement(t *testing.T) {
+	got := RedactStatement(`SELECT * FROM product WHERE name = 'a''b' AND price > 10.5 AND id = ?`)
+	want := `SELECT * FROM product WHERE name = ? AND price > ? AND id = ?`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	tracer := mocktracer.New()
+	db, err := Open("sqlite", ":memory:", WithTracer(tracer), WithInstance("catalog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// 没有父 span 时不产生 span
+	if _, err := db.Exec(`CREATE TABLE product (id INTEGER PRIMARY KEY, name TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tracer.FinishedSpans()); n != 0 {
+		t.Fatalf("expected no spans without parent, got %d", n)
+	}
+
+	parent := tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO product (id, name) VALUES (1, 'secret'), (2, ?)`, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, name FROM product WHERE id > ?`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	if _, err := db.ExecContext(ctx, `SELECT * FROM missing`); err == nil {
+		t.Fatal("expected error")
+	}
+
+	spans := map[string]*mocktracer.MockSpan{}
+	for _, s := range tracer.FinishedSpans() {
+		if s.ParentID != parent.(*mocktracer.MockSpan).SpanContext.SpanID {
+			t.Errorf("span %s has wrong parent", s.OperationName)
+		}
+		if s.Tag("db.type") != "sqlite" || s.Tag("db.instance") != "catalog" {
+			t.Errorf("span %s missing db tags: %v", s.OperationName, s.Tags())
+		}
+		stmt, _ := s.Tag("db.statement").(string)
+		spans[s.OperationName+"/"+stmt] = s
+	}
+
+	insert := spans["sql.exec/INSERT INTO product (id, name) VALUES (?, ?), (?, ?)"]
+	if insert == nil || insert.Tag("db.rows_affected") != int64(2) {
+		t.Fatalf("insert span not found or wrong rows_affected: %v", spans)
+	}
+	if tx := spans["sql.tx/"]; tx == nil || tx.Tag("db.tx.outcome") != "commit" {
+		t.Fatalf("tx span not found: %v", spans)
+	}
+	if q := spans["sql.query/SELECT id, name FROM product WHERE id > ?"]; q == nil || q.Tag("db.rows") != 2 {
+		t.Fatalf("query span not found or wrong rows: %v", spans)
+	}
+	if e := spans["sql.exec/SELECT * FROM missing"]; e == nil || e.Tag("error") != true {
+		t.Fatalf("error span not tagged: %v", spans)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	tracer := mocktracer.New()
+	b := breaker.New("sqlite", breaker.Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	db, err := Open("sqlite", ":memory:", WithTracer(tracer), WithBreaker(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("parent"))
+	if _, err := db.ExecContext(ctx, `SELECT * FROM missing`); err == nil {
+		t.Fatal("expected error")
+	}
+	tracer.Reset()
+	if _, err := db.ExecContext(ctx, `SELECT 1`); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("expected breaker error, got %v", err)
+	}
+	if n := len(tracer.FinishedSpans()); n != 0 {
+		t.Fatalf("rejected statement should not produce spans, got %d", n)
+	}
+}
+
+// skipConn 和 go-sql-driver/mysql 未开启 interpolateParams 时一样，带参数的 ExecContext 和 QueryContext
+// 返回 driver.ErrSkip，由 database/sql 改走 Prepare
+type skipConn struct{}
+
+func (skipConn) Open(string) (driver.Conn, error)    { return skipConn{}, nil }
+func (skipConn) Prepare(string) (driver.Stmt, error) { return skipStmt{}, nil }
+func (skipConn) Close() error                        { return nil }
+func (skipConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+func (skipConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+func (skipConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+type skipStmt struct{}
+
+func (skipStmt) Close() error                               { return nil }
+func (skipStmt) NumInput() int                              { return -1 }
+func (skipStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (skipStmt) Query([]driver.Value) (driver.Rows, error)  { return emptyRows{}, nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return []string{"id"} }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func TestErrSkip(t *testing.T) {
+	tracer := mocktracer.New()
+	b := breaker.New("skip", breaker.Settings{FailureThreshold: 1, CoolDown: time.Minute})
+	sql.Register("sqltrace-skip", Wrap(skipConn{}, WithTracer(tracer), WithBreaker(b)))
+	db, err := sql.Open("sqltrace-skip", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("parent"))
+	if _, err := db.ExecContext(ctx, `UPDATE product SET name = ? WHERE id = ?`, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT id FROM product WHERE id = ?`, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	// 返回 ErrSkip 的那次调用没有 span，只有 Prepare 和预处理语句的执行
+	var ops []string
+	for _, s := range tracer.FinishedSpans() {
+		ops = append(ops, s.OperationName)
+		if s.Tag("error") != nil {
+			t.Errorf("span %s should not be tagged as error", s.OperationName)
+		}
+	}
+	if len(ops) != 4 || ops[0] != "sql.prepare" || ops[1] != "sql.exec" || ops[2] != "sql.prepare" || ops[3] != "sql.query" {
+		t.Fatalf("unexpected spans %v", ops)
+	}
+	if b.State() != breaker.Closed {
+		t.Fatalf("expected breaker to stay closed, got %s", b.State())
+	}
+}
@@ -0,0 +1,87 @@
+package tenant
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"opentracing-sample/config"
+)
+
+// check 以 authorization 令牌中的租户为准：带令牌的调用拒绝无效令牌、没有租户的令牌和与 baggage 不一致的租户；
+// 不带令牌的调用只有在 baggage 中也没有租户时才放行
+func check(ctx context.Context, secret []byte) (context.Context, error) {
+	baggageTenant := config.GetBaggage(ctx, config.BaggageTenant)
+
+	var auth string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get("authorization"); len(vs) > 0 {
+			auth = vs[0]
+		}
+	}
+	if auth == "" && baggageTenant == "" {
+		return ctx, nil
+	}
+
+	span := opentracing.SpanFromContext(ctx)
+	deny := func(reason string, args ...interface{}) error {
+		if span != nil {
+			ext.Error.Set(span, true)
+			span.SetTag("tenant.mismatch", true)
+		}
+		config.Log.WithField("component", "tenant").Warnf(reason, args...)
+		return status.Errorf(codes.PermissionDenied, "tenant %q not allowed for this token", baggageTenant)
+	}
+
+	if auth == "" {
+		return ctx, deny("tenant %q in metadata without a token", baggageTenant)
+	}
+	claims, err := ParseToken(BearerToken(auth), secret)
+	if err != nil {
+		return ctx, deny("invalid token: %v", err)
+	}
+	if claims.Tenant == "" {
+		return ctx, deny("token has no tenant")
+	}
+	if baggageTenant != "" && baggageTenant != claims.Tenant {
+		return ctx, deny("tenant %q in metadata does not match token tenant %q", baggageTenant, claims.Tenant)
+	}
+	if span != nil {
+		span.SetTag(Tag, claims.Tenant)
+	}
+	return NewContext(ctx, claims.Tenant), nil
+}
+
+// UnaryServerInterceptor 拒绝租户与令牌不一致的调用，需要放在 opentracing 拦截器之后
+func UnaryServerInterceptor(secret []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := check(ctx, secret)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 是 UnaryServerInterceptor 的流式版本
+func StreamServerInterceptor(secret []byte) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := check(ss.Context(), secret)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
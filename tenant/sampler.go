@@ -0,0 +1,125 @@
+package tenant
+
+import (
+	"fmt"
+	"github.com/uber/jaeger-client-go"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sampler 按租户设置采样率。新 trace 先不做决定，等根 span 打上 tenant 标签时再按该租户的比例采样，
+// 直到 span 结束都没有租户则使用默认比例。从上游继承了采样决定的 trace 不受影响。
+type Sampler struct {
+	jaeger.SamplerV2Base
+
+	mu    sync.RWMutex
+	rates map[string]float64
+	deflt float64
+}
+
+// NewSampler 创建按租户采样的 Sampler，rates 中没有的租户使用 defaultRate
+func NewSampler(defaultRate float64, rates map[string]float64) *Sampler {
+	s := &Sampler{}
+	s.Update(defaultRate, rates)
+	return s
+}
+
+// Update 替换采样率，可以在运行时调用
+func (s *Sampler) Update(defaultRate float64, rates map[string]float64) {
+	copied := make(map[string]float64, len(rates))
+	for k, v := range rates {
+		copied[k] = v
+	}
+	s.mu.Lock()
+	s.deflt = defaultRate
+	s.rates = copied
+	s.mu.Unlock()
+}
+
+func (s *Sampler) rate(tenant string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if r, ok := s.rates[tenant]; ok {
+		return r
+	}
+	return s.deflt
+}
+
+// decide 与 jaeger 的 probabilistic sampler 一样用 trace ID 的低 63 位做比较，同一 trace 的结果稳定
+func (s *Sampler) decide(span *jaeger.Span, tenant string) jaeger.SamplingDecision {
+	rate := s.rate(tenant)
+	boundary := uint64(float64(math.MaxInt64) * rate)
+	low := span.SpanContext().TraceID().Low & math.MaxInt64
+	sampled := rate >= 1 || low < boundary
+	return jaeger.SamplingDecision{
+		Sample:    sampled,
+		Retryable: false,
+		Tags: []jaeger.Tag{
+			jaeger.NewTag("sampler.type", "tenant"),
+			jaeger.NewTag("sampler.param", rate),
+		},
+	}
+}
+
+func (s *Sampler) OnCreateSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Retryable: true}
+}
+
+func (s *Sampler) OnSetOperationName(span *jaeger.Span, operationName string) jaeger.SamplingDecision {
+	return jaeger.SamplingDecision{Retryable: true}
+}
+
+func (s *Sampler) OnSetTag(span *jaeger.Span, key string, value interface{}) jaeger.SamplingDecision {
+	if key != Tag {
+		return jaeger.SamplingDecision{Retryable: true}
+	}
+	t, _ := value.(string)
+	return s.decide(span, t)
+}
+
+func (s *Sampler) OnFinishSpan(span *jaeger.Span) jaeger.SamplingDecision {
+	return s.decide(span, "")
+}
+
+func (s *Sampler) Close() {}
+
+// ParseRates 解析 "acme=1,free=0.01" 形式的租户采样率
+func ParseRates(s string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("tenant: invalid sampling rate %q", item)
+		}
+		rate, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("tenant: invalid sampling rate %q", item)
+		}
+		rates[strings.TrimSpace(kv[0])] = rate
+	}
+	return rates, nil
+}
+
+// SamplerFromEnv 用 SAMPLING_RATE（默认 1）和 TENANT_SAMPLING 创建 Sampler
+func SamplerFromEnv() (*Sampler, error) {
+	defaultRate := 1.0
+	if v := os.Getenv("SAMPLING_RATE"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r < 0 || r > 1 {
+			return nil, fmt.Errorf("tenant: invalid SAMPLING_RATE %q", v)
+		}
+		defaultRate = r
+	}
+	rates, err := ParseRates(os.Getenv("TENANT_SAMPLING"))
+	if err != nil {
+		return nil, err
+	}
+	return NewSampler(defaultRate, rates), nil
+}
@@ -0,0 +1,111 @@
+// Package tenant 解析请求所属租户，并让租户贯穿上下文、baggage、span 标签和采样
+package tenant
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Tag 是记录租户的 span 标签
+const Tag = "tenant"
+
+var validRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Valid 判断租户 ID 是否合法，只允许小写字母、数字和 -，最长 63 个字符
+func Valid(tenant string) bool {
+	return validRe.MatchString(tenant)
+}
+
+type contextKey struct{}
+
+// NewContext 把租户存入 ctx
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext 读取 ctx 中的租户
+func FromContext(ctx context.Context) string {
+	t, _ := ctx.Value(contextKey{}).(string)
+	return t
+}
+
+// Resolver 从 HTTP 请求中解析租户，解析不到时返回空字符串
+type Resolver interface {
+	Resolve(r *http.Request) string
+}
+
+// ResolverFunc 让普通函数实现 Resolver
+type ResolverFunc func(r *http.Request) string
+
+func (f ResolverFunc) Resolve(r *http.Request) string {
+	return f(r)
+}
+
+func normalize(tenant string) string {
+	tenant = strings.ToLower(strings.TrimSpace(tenant))
+	if !Valid(tenant) {
+		return ""
+	}
+	return tenant
+}
+
+// FromHeader 从请求头读取租户，如 X-Tenant-ID
+func FromHeader(name string) Resolver {
+	return ResolverFunc(func(r *http.Request) string {
+		return normalize(r.Header.Get(name))
+	})
+}
+
+// FromToken 从 Authorization: Bearer 令牌中读取租户声明，令牌签名校验失败时忽略
+func FromToken(secret []byte) Resolver {
+	return ResolverFunc(func(r *http.Request) string {
+		claims, err := ParseToken(BearerToken(r.Header.Get("Authorization")), secret)
+		if err != nil {
+			return ""
+		}
+		return normalize(claims.Tenant)
+	})
+}
+
+// FromSubdomain 从 <tenant>.<baseDomain> 形式的 Host 中读取租户
+func FromSubdomain(baseDomain string) Resolver {
+	suffix := "." + strings.ToLower(strings.TrimPrefix(baseDomain, "."))
+	return ResolverFunc(func(r *http.Request) string {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		sub := strings.TrimSuffix(host, suffix)
+		if strings.Contains(sub, ".") {
+			return ""
+		}
+		return normalize(sub)
+	})
+}
+
+// Chain 依次尝试多个 Resolver，返回第一个解析到的租户
+func Chain(resolvers ...Resolver) Resolver {
+	return ResolverFunc(func(r *http.Request) string {
+		for _, resolver := range resolvers {
+			if t := resolver.Resolve(r); t != "" {
+				return t
+			}
+		}
+		return ""
+	})
+}
+
+// BearerToken 从 Authorization 头中取出 Bearer 令牌
+func BearerToken(authorization string) string {
+	const prefix = "bearer "
+	if len(authorization) > len(prefix) && strings.ToLower(authorization[:len(prefix)]) == prefix {
+		return strings.TrimSpace(authorization[len(prefix):])
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package tenant
+
+import (
+	"context"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http/httptest"
+	"opentracing-sample/config"
+	"testing"
+	"time"
+)
+
+var secret = []byte("test-secret")
+
+func TestResolvers(t *testing.T) {
+	token, _ := SignToken(Claims{Subject: "alice", Tenant: "acme"}, secret)
+	expired, _ := SignToken(Claims{Tenant: "acme", ExpiresAt: time.Now().Add(-time.Minute).Unix()}, secret)
+	forged, _ := SignToken(Claims{Tenant: "acme"}, []byte("other"))
+
+	resolver := Chain(FromHeader("X-Tenant-ID"), FromToken(secret), FromSubdomain("shop.example.com"))
+	cases := []struct {
+		name, host, header, auth, want string
+	}{
+		{"header", "shop.example.com", "Globex", "", "globex"},
+		{"invalid header falls through", "shop.example.com", "bad tenant!", "Bearer " + token, "acme"},
+		{"token", "shop.example.com", "", "Bearer " + token, "acme"},
+		{"expired token", "shop.example.com", "", "Bearer " + expired, ""},
+		{"forged token", "shop.example.com", "", "Bearer " + forged, ""},
+		{"subdomain", "initech.shop.example.com:8080", "", "", "initech"},
+		{"nested subdomain", "a.b.shop.example.com", "", "", ""},
+		{"none", "localhost", "", "", ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "http://"+tc.host+"/api/product", nil)
+		if tc.header != "" {
+			req.Header.Set("X-Tenant-ID", tc.header)
+		}
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		if got := resolver.Resolve(req); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTracerTagsChildren(t *testing.T) {
+	mt := mocktracer.New()
+	tracer := NewTracer(mt)
+	root := tracer.StartSpan("root")
+	root.SetBaggageItem(config.BaggageTenant, "acme")
+	child := tracer.StartSpan("child", opentracing.ChildOf(root.Context()))
+	child.Finish()
+	if got := mt.FinishedSpans()[0].Tag(Tag); got != "acme" {
+		t.Fatalf("child span tenant tag = %v", got)
+	}
+}
+
+func TestSamplerPerTenant(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	sampler := NewSampler(0, map[string]float64{"acme": 1})
+	tracer, closer := jaeger.NewTracer("test", sampler, reporter)
+	defer closer.Close()
+
+	for _, tn := range []string{"acme", "free", ""} {
+		span := tracer.StartSpan("GET /api/product/:id")
+		if tn != "" {
+			span.SetTag(Tag, tn)
+		}
+		child := tracer.StartSpan("child", opentracing.ChildOf(span.Context()))
+		child.Finish()
+		span.Finish()
+	}
+
+	spans := reporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected only the acme trace to be sampled, got %d spans", len(spans))
+	}
+	for _, s := range spans {
+		if !s.(*jaeger.Span).SpanContext().IsSampled() {
+			t.Error("reported span is not sampled")
+		}
+	}
+
+	sampler.Update(1, nil)
+	span := tracer.StartSpan("GET /api/product")
+	span.Finish()
+	if reporter.SpansSubmitted() != 3 {
+		t.Fatal("default rate update not applied")
+	}
+}
+
+func TestParseRates(t *testing.T) {
+	rates, err := ParseRates("acme=1, free=0.01")
+	if err != nil || rates["acme"] != 1 || rates["free"] != 0.01 {
+		t.Fatalf("got %v, %v", rates, err)
+	}
+	if _, err := ParseRates("acme=2"); err == nil {
+		t.Fatal("expected error for rate > 1")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	mt := mocktracer.New()
+	token, _ := SignToken(Claims{Tenant: "acme"}, secret)
+
+	untenanted, _ := SignToken(Claims{Subject: "alice"}, secret)
+	forged, _ := SignToken(Claims{Tenant: "acme"}, []byte("other"))
+
+	call := func(baggageTenant, auth string) (string, error) {
+		span := mt.StartSpan("server")
+		if baggageTenant != "" {
+			span.SetBaggageItem(config.BaggageTenant, baggageTenant)
+		}
+		ctx := opentracing.ContextWithSpan(context.Background(), span)
+		if auth != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", auth))
+		}
+		ctx, err := check(ctx, secret)
+		return FromContext(ctx), err
+	}
+
+	if tenant, err := call("", ""); err != nil || tenant != "" {
+		t.Fatalf("anonymous calls should pass without tenant: %q, %v", tenant, err)
+	}
+	for _, baggageTenant := range []string{"acme", ""} {
+		// 没有 baggage 时从令牌中解析租户
+		if tenant, err := call(baggageTenant, "Bearer "+token); err != nil || tenant != "acme" {
+			t.Fatalf("baggage %q: got tenant %q, %v", baggageTenant, tenant, err)
+		}
+	}
+	for _, c := range []struct{ baggage, auth string }{
+		{"globex", "Bearer " + token},
+		{"acme", ""},
+		{"", "Bearer " + forged},
+		{"", "Bearer " + untenanted},
+	} {
+		if _, err := call(c.baggage, c.auth); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("%+v: expected PermissionDenied, got %v", c, err)
+		}
+	}
+}
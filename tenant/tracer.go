@@ -0,0 +1,43 @@
+package tenant
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"opentracing-sample/config"
+)
+
+// Tracer 包装 opentracing.Tracer，新 span 的父 span 带有租户 baggage 时自动打上 tenant 标签
+type Tracer struct {
+	opentracing.Tracer
+}
+
+// NewTracer 包装 tracer
+func NewTracer(tracer opentracing.Tracer) *Tracer {
+	return &Tracer{Tracer: tracer}
+}
+
+func (t *Tracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	var sso opentracing.StartSpanOptions
+	for _, o := range opts {
+		o.Apply(&sso)
+	}
+	if _, ok := sso.Tags[Tag]; !ok {
+		for _, ref := range sso.References {
+			if ref.ReferencedContext == nil {
+				continue
+			}
+			var tenant string
+			ref.ReferencedContext.ForeachBaggageItem(func(k, v string) bool {
+				if k == config.BaggageTenant {
+					tenant = v
+					return false
+				}
+				return true
+			})
+			if tenant != "" {
+				opts = append(opts, opentracing.Tag{Key: Tag, Value: tenant})
+				break
+			}
+		}
+	}
+	return t.Tracer.StartSpan(operationName, opts...)
+}